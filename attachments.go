@@ -0,0 +1,348 @@
+package srco
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Largest single file accepted by uploadAttachmentHandler
+	maxAttachmentSize int64 = 10 << 20
+
+	// Total size of attachments a single user may store
+	attachmentQuota int64 = 100 << 20
+
+	errQuotaExceeded = errors.New("Upload quota exceeded")
+
+	// Content types accepted after sniffing the uploaded bytes
+	allowedAttachmentTypes = map[string]bool{
+		"image/jpeg":                true,
+		"image/png":                 true,
+		"image/gif":                 true,
+		"image/webp":                true,
+		"application/pdf":           true,
+		"text/plain; charset=utf-8": true,
+	}
+)
+
+func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	userID, _ := strconv.Atoi(r.FormValue("user_id"))
+	postID, _ := strconv.Atoi(r.FormValue("post_id"))
+
+	// Verify the user owns this post
+	var postUserID int
+	err := db.QueryRow("SELECT user_id FROM posts WHERE id = ? AND deleted_at IS NULL", postID).Scan(&postUserID)
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	if postUserID != userID {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	attachment, status, err := storeAttachment(file, header.Filename, header.Size)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if status, err := insertAttachment(userID, postID, attachment); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	attachment.PostID = postID
+	attachment.URL = attachmentURL(attachment.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// storeAttachment checks size and content type of an upload and writes it to
+// the blob store; insertAttachment then records it against the uploader's
+// quota. On failure it returns the HTTP status to respond with.
+func storeAttachment(file io.Reader, filename string, size int64) (*Attachment, int, error) {
+	if size > maxAttachmentSize {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("File must be %d MB or less", maxAttachmentSize>>20)
+	}
+
+	// Sniff the content type from the data rather than trusting the client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, http.StatusBadRequest, fmt.Errorf("Error reading file")
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	if !allowedAttachmentTypes[contentType] {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("File type not allowed")
+	}
+
+	key, err := newBlobKey()
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Error saving file")
+	}
+	if err := blobStore.Put(key, io.MultiReader(bytes.NewReader(head), file)); err != nil {
+		log.Printf("Blob store error: %v", err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("Error saving file")
+	}
+
+	return &Attachment{
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        size,
+		blobKey:     key,
+	}, http.StatusOK, nil
+}
+
+// insertAttachment records an attachment written by storeAttachment, for postID
+// or, if it is 0, for a chat message, and sets its ID. If that fails the
+// attachment's blobs are deleted and it returns the HTTP status to respond with.
+func insertAttachment(userID, postID int, attachment *Attachment) (int, error) {
+	err := recordAttachment(userID, postID, attachment)
+	if err == nil {
+		return http.StatusCreated, nil
+	}
+
+	blobStore.Delete(attachment.blobKey)
+	if attachment.thumbnailKey != "" {
+		blobStore.Delete(attachment.thumbnailKey)
+	}
+	if err == errQuotaExceeded {
+		return http.StatusRequestEntityTooLarge, err
+	}
+	log.Printf("Error saving attachment: %v", err)
+	return http.StatusInternalServerError, fmt.Errorf("Error saving attachment")
+}
+
+// recordAttachment checks the quota and inserts the attachment in one
+// transaction, so concurrent uploads cannot exceed the quota together
+func recordAttachment(userID, postID int, attachment *Attachment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var used int64
+	err = tx.QueryRow("SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?", userID).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used+attachment.Size > attachmentQuota {
+		return errQuotaExceeded
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO attachments (post_id, user_id, filename, content_type, size, blob_key, thumbnail_key) 
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		sql.NullInt64{Int64: int64(postID), Valid: postID != 0}, userID, attachment.Filename,
+		attachment.ContentType, attachment.Size, attachment.blobKey,
+		sql.NullString{String: attachment.thumbnailKey, Valid: attachment.thumbnailKey != ""})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	id, _ := result.LastInsertId()
+	attachment.ID = int(id)
+	return nil
+}
+
+func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	attachmentID := r.URL.Query().Get("id")
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	var exists int
+	if err := db.QueryRow("SELECT id FROM users WHERE id = ?", userID).Scan(&exists); err != nil {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	// Attachments of drafts are only visible to their author
+	var attachment Attachment
+	err := db.QueryRow(`
+        SELECT a.filename, a.content_type, a.size, a.blob_key
+        FROM attachments a
+        JOIN posts p ON a.post_id = p.id
+        WHERE a.id = ? AND p.deleted_at IS NULL
+        AND (p.status = 'published' OR p.user_id = ?)`, attachmentID, userID).Scan(
+		&attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.blobKey)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	serveBlob(w, r, attachment.blobKey, attachment.ContentType, attachment.Filename, attachment.Size)
+}
+
+// serveBlob writes a stored file with caching headers. Blobs never change once
+// written, so the key doubles as a strong ETag.
+func serveBlob(w http.ResponseWriter, r *http.Request, key, contentType, filename string, size int64) {
+	etag := `"` + key + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := blobStore.Get(key)
+	if err != nil {
+		log.Printf("Blob store error: %v", err)
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	defer blob.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("Error sending attachment: %v", err)
+	}
+}
+
+func getPostAttachments(postID int) []Attachment {
+	attachments := []Attachment{}
+	rows, err := db.Query(`
+        SELECT id, post_id, filename, content_type, size, created_at
+        FROM attachments
+        WHERE post_id = ?
+        ORDER BY id`, postID)
+	if err != nil {
+		log.Printf("Error fetching attachments: %v", err)
+		return attachments
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attachment Attachment
+		if err := rows.Scan(&attachment.ID, &attachment.PostID, &attachment.Filename,
+			&attachment.ContentType, &attachment.Size, &attachment.CreatedAt); err != nil {
+			log.Printf("Error scanning attachment: %v", err)
+			continue
+		}
+		attachment.URL = attachmentURL(attachment.ID)
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+func attachmentURL(id int) string {
+	return fmt.Sprintf("/attachment?id=%d", id)
+}
+
+func newBlobKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	return name
+}
+
+// deleteWithAttachments deletes the attachments matching where, then runs
+// queries, all in one transaction with args passed to each statement. The
+// attachments' blobs are deleted once the transaction commits, so a failure
+// there only leaves orphaned files. It returns the result of the last query.
+func deleteWithAttachments(where string, args []interface{}, queries ...string) (sql.Result, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var blobKeys []string
+	rows, err := tx.Query("SELECT blob_key, COALESCE(thumbnail_key, '') FROM attachments WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key, thumbnailKey string
+		if err := rows.Scan(&key, &thumbnailKey); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blobKeys = append(blobKeys, key)
+		if thumbnailKey != "" {
+			blobKeys = append(blobKeys, thumbnailKey)
+		}
+	}
+	rows.Close()
+
+	result, err := tx.Exec("DELETE FROM attachments WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, query := range queries {
+		if result, err = tx.Exec(query, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, key := range blobKeys {
+		if err := blobStore.Delete(key); err != nil {
+			log.Printf("Error deleting blob %s: %v", key, err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package srco
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useMemoryBlobStore swaps blobStore for an empty in-memory store for the test
+func useMemoryBlobStore(t *testing.T) *MemoryBlobStore {
+	t.Helper()
+
+	previous := blobStore
+	store := NewMemoryBlobStore()
+	blobStore = store
+	t.Cleanup(func() { blobStore = previous })
+	return store
+}
+
+// uploadTestFile posts data as a multipart upload to handler
+func uploadTestFile(handler http.HandlerFunc, fields map[string]string, filename string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	part, _ := form.CreateFormFile("file", filename)
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestUploadAttachmentLimits(t *testing.T) {
+	openTestDB(t)
+	store := useMemoryBlobStore(t)
+	previous := attachmentQuota
+	attachmentQuota = 100
+	t.Cleanup(func() { attachmentQuota = previous })
+
+	author := createTestUser(t, "alice")
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content, category) VALUES (?, 'title', 'body', 'general')", author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, _ := result.LastInsertId()
+	fields := map[string]string{"user_id": strconv.Itoa(author), "post_id": strconv.FormatInt(postID, 10)}
+
+	countAttachments := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM attachments").Scan(&n)
+		return n
+	}
+
+	// The type is sniffed from the bytes, not taken from the name
+	executable := append([]byte("MZ\x90\x00\x03\x00\x00\x00"), make([]byte, 56)...)
+	if w := uploadTestFile(uploadAttachmentHandler, fields, "notes.txt", executable); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("executable upload = %d, want 415", w.Code)
+	}
+
+	text := []byte(strings.Repeat("sixty bytes of text. ", 3)[:60])
+	if w := uploadTestFile(uploadAttachmentHandler, fields, "notes.txt", text); w.Code != http.StatusCreated {
+		t.Fatalf("upload within quota = %d %s", w.Code, w.Body)
+	}
+	if w := uploadTestFile(uploadAttachmentHandler, fields, "more.txt", text); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over quota = %d, want 413", w.Code)
+	}
+
+	if n := countAttachments(); n != 1 {
+		t.Errorf("%d attachments stored, want 1", n)
+	}
+	if n := len(store.blobs); n != 1 {
+		t.Errorf("%d blobs left in the store, want only the accepted upload's", n)
+	}
+}
+
+func TestServeBlobNotModified(t *testing.T) {
+	openTestDB(t)
+	useMemoryBlobStore(t)
+	alice := createTestUser(t, "alice")
+
+	w := uploadTestFile(uploadChatAttachmentHandler, map[string]string{"user_id": strconv.Itoa(alice)}, "notes.txt", []byte("hello"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload = %d %s", w.Code, w.Body)
+	}
+	var attachment Attachment
+	json.NewDecoder(w.Body).Decode(&attachment)
+
+	download := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, attachment.URL+"&user_id="+strconv.Itoa(alice), nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		downloadChatAttachmentHandler(w, req)
+		return w
+	}
+
+	first := download("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Body.String() != "hello" || etag == "" {
+		t.Fatalf("download = %d %q with ETag %q", first.Code, first.Body, etag)
+	}
+
+	again := download(etag)
+	if again.Code != http.StatusNotModified || again.Body.Len() != 0 {
+		t.Errorf("download with a matching ETag = %d with %d bytes, want 304 and no body", again.Code, again.Body.Len())
+	}
+	if stale := download(`"other"`); stale.Code != http.StatusOK {
+		t.Errorf("download with a stale ETag = %d, want 200", stale.Code)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/bcrypt"
@@ -105,9 +106,9 @@ func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The profile picture is set through uploadAvatarHandler
 	var profile struct {
 		UserID         int    `json:"user_id"`
-		ProfilePic     string `json:"profile_pic"`
 		ProfileThought string `json:"profile_thought"`
 	}
 
@@ -124,9 +125,9 @@ func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
 
 	_, err := db.Exec(`
         UPDATE users 
-        SET profile_pic = ?, profile_thought = ? 
+        SET profile_thought = ? 
         WHERE id = ?`,
-		profile.ProfilePic, profile.ProfileThought, profile.UserID)
+		profile.ProfileThought, profile.UserID)
 	if err != nil {
 		http.Error(w, "Error updating profile", http.StatusInternalServerError)
 		return
@@ -144,15 +145,17 @@ func getUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var profile UserProfile
+	var avatarKey string
 	err := db.QueryRow(`
         SELECT id, nickname, age, gender, first_name, last_name, email,
                COALESCE(profile_pic, 'default-profile.jpg') as profile_pic,
-               COALESCE(profile_thought, '') as profile_thought
+               COALESCE(profile_thought, '') as profile_thought,
+               COALESCE(avatar_key, '') as avatar_key
         FROM users 
         WHERE id = ?`, userID).Scan(
 		&profile.ID, &profile.Nickname, &profile.Age, &profile.Gender,
 		&profile.FirstName, &profile.LastName, &profile.Email,
-		&profile.ProfilePic, &profile.ProfileThought)
+		&profile.ProfilePic, &profile.ProfileThought, &avatarKey)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -171,11 +174,16 @@ func getUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if avatarKey != "" {
+		profile.Avatars = avatarURLs(avatarKey)
+		profile.ProfilePic = profile.Avatars[strconv.Itoa(avatarSizes[len(avatarSizes)-1])]
+	}
+
 	// Fetch user's posts
 	rows, err := db.Query(`
         SELECT id, title, content, category, created_at
         FROM posts
-        WHERE user_id = ?
+        WHERE user_id = ? AND deleted_at IS NULL AND status = 'published'
         ORDER BY created_at DESC`, userID)
 	if err != nil {
 		log.Printf("Error fetching user posts: %v", err)
@@ -195,3 +203,9 @@ func getUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(profile)
 }
+
+func isModerator(userID int) bool {
+	var role string
+	err := db.QueryRow("SELECT COALESCE(role, 'user') FROM users WHERE id = ?", userID).Scan(&role)
+	return err == nil && (role == "moderator" || role == "admin")
+}
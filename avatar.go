@@ -0,0 +1,203 @@
+package srco
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+var (
+	// Square sizes, in pixels, generated for every uploaded avatar
+	avatarSizes = []int{32, 64, 256}
+
+	// Largest avatar file accepted by uploadAvatarHandler
+	maxAvatarSize int64 = 5 << 20
+
+	// Images with more pixels than this are rejected before being decoded
+	maxAvatarPixels = 40000000
+
+	allowedAvatarTypes = map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/gif":  true,
+	}
+)
+
+func uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	userID, _ := strconv.Atoi(r.FormValue("user_id"))
+
+	var oldKey string
+	err := db.QueryRow("SELECT COALESCE(avatar_key, '') FROM users WHERE id = ?", userID).Scan(&oldKey)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxAvatarSize {
+		http.Error(w, fmt.Sprintf("Avatar must be %d MB or less", maxAvatarSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusBadRequest)
+		return
+	}
+
+	if !allowedAvatarTypes[http.DetectContentType(data)] {
+		http.Error(w, "Avatar must be a JPEG, PNG or GIF image", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "Invalid image", http.StatusBadRequest)
+		return
+	}
+	if config.Width*config.Height > maxAvatarPixels {
+		http.Error(w, "Image dimensions are too large", http.StatusBadRequest)
+		return
+	}
+
+	// Only the decoded pixels are re-encoded, which drops EXIF and any other metadata
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "Invalid image", http.StatusBadRequest)
+		return
+	}
+
+	key, err := newBlobKey()
+	if err != nil {
+		http.Error(w, "Error saving avatar", http.StatusInternalServerError)
+		return
+	}
+
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resizeSquare(img, size)); err != nil {
+			http.Error(w, "Error processing avatar", http.StatusInternalServerError)
+			return
+		}
+		if err := blobStore.Put(avatarBlobKey(key, size), &buf); err != nil {
+			log.Printf("Blob store error: %v", err)
+			deleteAvatarBlobs(key)
+			http.Error(w, "Error saving avatar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_, err = db.Exec("UPDATE users SET avatar_key = ? WHERE id = ?", key, userID)
+	if err != nil {
+		deleteAvatarBlobs(key)
+		http.Error(w, "Error updating profile", http.StatusInternalServerError)
+		return
+	}
+
+	if oldKey != "" {
+		deleteAvatarBlobs(oldKey)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"avatars": avatarURLs(key),
+	})
+}
+
+func getAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	blobKey := r.URL.Query().Get("key")
+
+	// Keys look like "<avatar key>-<size>"
+	sep := strings.LastIndex(blobKey, "-")
+	if sep < 0 {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+	key := blobKey[:sep]
+	size, err := strconv.Atoi(blobKey[sep+1:])
+	if err != nil || !isAvatarSize(size) {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE avatar_key = ?", key).Scan(&userID); err != nil {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	serveBlob(w, r, blobKey, "image/png", fmt.Sprintf("avatar-%d.png", size), 0)
+}
+
+// resizeSquare center-crops src to a square and scales it to size x size
+func resizeSquare(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, image.Rect(x0, y0, x0+side, y0+side), draw.Over, nil)
+	return dst
+}
+
+func avatarURLs(key string) map[string]string {
+	urls := make(map[string]string, len(avatarSizes))
+	for _, size := range avatarSizes {
+		urls[strconv.Itoa(size)] = "/avatar?key=" + avatarBlobKey(key, size)
+	}
+	return urls
+}
+
+func avatarBlobKey(key string, size int) string {
+	return fmt.Sprintf("%s-%d", key, size)
+}
+
+func isAvatarSize(size int) bool {
+	for _, s := range avatarSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+func deleteAvatarBlobs(key string) {
+	for _, size := range avatarSizes {
+		if err := blobStore.Delete(avatarBlobKey(key, size)); err != nil {
+			log.Printf("Error deleting blob %s: %v", avatarBlobKey(key, size), err)
+		}
+	}
+}
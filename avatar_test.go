@@ -0,0 +1,101 @@
+package srco
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// jpegWithEXIF encodes a small photo and inserts an EXIF block carrying marker
+// right after the start-of-image marker, where cameras put it
+func jpegWithEXIF(t *testing.T, marker string) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exif := append([]byte("Exif\x00\x00"), marker...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	segment = append(segment, exif...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+// pngChunkTypes lists the chunk types of an encoded PNG in order
+func pngChunkTypes(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	var types []string
+	for data = data[8:]; len(data) >= 12; {
+		length := binary.BigEndian.Uint32(data)
+		types = append(types, string(data[4:8]))
+		data = data[12+length:]
+	}
+	return types
+}
+
+func TestAvatarUploadStripsMetadata(t *testing.T) {
+	openTestDB(t)
+	store := useMemoryBlobStore(t)
+	alice := createTestUser(t, "alice")
+
+	const marker = "GPS 51.5007N 0.1246W"
+	upload := jpegWithEXIF(t, marker)
+	if !bytes.Contains(upload, []byte(marker)) {
+		t.Fatal("test image has no EXIF block")
+	}
+
+	w := uploadTestFile(uploadAvatarHandler, map[string]string{"user_id": strconv.Itoa(alice)}, "me.jpg", upload)
+	if w.Code != http.StatusOK {
+		t.Fatalf("avatar upload = %d %s", w.Code, w.Body)
+	}
+
+	var key string
+	if err := db.QueryRow("SELECT avatar_key FROM users WHERE id = ?", alice).Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(store.blobs); n != len(avatarSizes) {
+		t.Errorf("%d blobs stored, want one per size", n)
+	}
+
+	for _, size := range []int{32, 64, 256} {
+		data, ok := store.blobs[avatarBlobKey(key, size)]
+		if !ok {
+			t.Errorf("no %dpx avatar stored", size)
+			continue
+		}
+		if bytes.Contains(data, []byte(marker)) {
+			t.Errorf("%dpx avatar still contains the EXIF data", size)
+		}
+		for _, chunk := range pngChunkTypes(t, data) {
+			if chunk != "IHDR" && chunk != "IDAT" && chunk != "IEND" {
+				t.Errorf("%dpx avatar has a %s chunk", size, chunk)
+			}
+		}
+
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%dpx avatar is not a PNG: %v", size, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("%dpx avatar is %dx%d", size, b.Dx(), b.Dy())
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package srco
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// A user relation is a row in user_blocks or user_mutes pointing from a user to someone they act on
+type userRelation struct {
+	table  string
+	column string
+}
+
+var (
+	blockRelation = userRelation{table: "user_blocks", column: "blocked_id"}
+	muteRelation  = userRelation{table: "user_mutes", column: "muted_id"}
+)
+
+// isBlockedBetween reports whether either user has blocked the other
+func isBlockedBetween(userID, otherID int) bool {
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM user_blocks 
+		WHERE (user_id = ? AND blocked_id = ?) OR (user_id = ? AND blocked_id = ?)`,
+		userID, otherID, otherID, userID).Scan(&n)
+	if err != nil {
+		log.Printf("Error checking blocks: %v", err)
+		return false
+	}
+	return n > 0
+}
+
+// blockedUserIDs returns everyone the user has blocked or been blocked by
+func blockedUserIDs(userID int) map[int]bool {
+	rows, err := db.Query(`
+		SELECT blocked_id FROM user_blocks WHERE user_id = ?
+		UNION
+		SELECT user_id FROM user_blocks WHERE blocked_id = ?`, userID, userID)
+	if err != nil {
+		log.Printf("Error checking blocks: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	blocked := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			blocked[id] = true
+		}
+	}
+	return blocked
+}
+
+func blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	updateUserRelation(w, r, blockRelation, true)
+}
+
+func unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	updateUserRelation(w, r, blockRelation, false)
+}
+
+func muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	updateUserRelation(w, r, muteRelation, true)
+}
+
+func unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	updateUserRelation(w, r, muteRelation, false)
+}
+
+func getBlockedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	listUserRelation(w, r, blockRelation)
+}
+
+func getMutedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	listUserRelation(w, r, muteRelation)
+}
+
+func updateUserRelation(w http.ResponseWriter, r *http.Request, rel userRelation, add bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		UserID   int `json:"user_id"`
+		TargetID int `json:"target_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if request.UserID <= 0 || request.TargetID <= 0 || request.UserID == request.TargetID {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if add {
+		_, err = db.Exec(`
+			INSERT OR IGNORE INTO `+rel.table+` (user_id, `+rel.column+`)
+			SELECT ?, id FROM users WHERE id = ?`,
+			request.UserID, request.TargetID)
+	} else {
+		_, err = db.Exec("DELETE FROM "+rel.table+" WHERE user_id = ? AND "+rel.column+" = ?",
+			request.UserID, request.TargetID)
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func listUserRelation(w http.ResponseWriter, r *http.Request, rel userRelation) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT u.id, u.nickname, x.created_at
+		FROM `+rel.table+` x
+		JOIN users u ON u.id = x.`+rel.column+`
+		WHERE x.user_id = ?
+		ORDER BY u.nickname`, userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []map[string]interface{}{}
+	for rows.Next() {
+		var id int
+		var nickname, since string
+		if err := rows.Scan(&id, &nickname, &since); err != nil {
+			continue
+		}
+		users = append(users, map[string]interface{}{
+			"id":       id,
+			"nickname": nickname,
+			"since":    since,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
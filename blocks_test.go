@@ -0,0 +1,159 @@
+package srco
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// block makes userID block targetID through the HTTP API
+func block(t *testing.T, userID, targetID int) {
+	t.Helper()
+	w := postJSON(blockUserHandler, "/block", map[string]int{"user_id": userID, "target_id": targetID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("block = %d %s", w.Code, w.Body)
+	}
+}
+
+// errorCodes returns the codes of the error frames queued for c
+func errorCodes(c *client) []string {
+	var codes []string
+	for _, frame := range receivedFrames(c) {
+		if frame["type"] == "error" {
+			codes = append(codes, frame["code"].(string))
+		}
+	}
+	return codes
+}
+
+func TestBlockedUserCannotMessage(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	a := connectTestClient(t, alice)
+	b := connectTestClient(t, bob)
+
+	// A message sent before the block can no longer be edited afterwards
+	dispatch(b, []byte(`{"type":"chat_message","content":{"to":`+strconv.Itoa(alice)+`,"content":"hi"}}`))
+	var messageID int
+	if err := db.QueryRow("SELECT id FROM chat_messages WHERE from_id = ?", bob).Scan(&messageID); err != nil {
+		t.Fatal(err)
+	}
+	drain(a)
+	drain(b)
+
+	block(t, alice, bob)
+
+	frames := []string{
+		`{"type":"chat_message","content":{"to":` + strconv.Itoa(alice) + `,"content":"hi again"}}`,
+		`{"type":"typing_status","content":{"to":` + strconv.Itoa(alice) + `,"isTyping":true}}`,
+		`{"type":"edit_message","content":{"message_id":` + strconv.Itoa(messageID) + `,"content":"edited"}}`,
+	}
+	for _, frame := range frames {
+		dispatch(b, []byte(frame))
+		if codes := errorCodes(b); len(codes) != 1 || codes[0] != "blocked" {
+			t.Errorf("%s: errors = %v, want [blocked]", frame, codes)
+		}
+	}
+	if got := receivedFrames(a); len(got) != 0 {
+		t.Errorf("blocking user received %v", got)
+	}
+
+	var content string
+	db.QueryRow("SELECT content FROM chat_messages WHERE id = ?", messageID).Scan(&content)
+	if content != "hi" {
+		t.Errorf("message content = %q after blocked edit, want unchanged", content)
+	}
+}
+
+func TestRoomBlocks(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+
+	createRoom := func(ownerID int, memberIDs ...int) *httptest.ResponseRecorder {
+		return postJSON(createRoomHandler, "/rooms", map[string]interface{}{
+			"user_id": ownerID, "name": "room", "member_ids": memberIDs,
+		})
+	}
+	w := createRoom(alice, bob, carol)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create room = %d %s", w.Code, w.Body)
+	}
+	var room Room
+	json.NewDecoder(w.Body).Decode(&room)
+
+	block(t, alice, bob)
+
+	// Neither side of a block can put the other in a room
+	if w := createRoom(bob, alice); w.Code != http.StatusForbidden {
+		t.Errorf("blocked user creating a room with the blocker = %d, want 403", w.Code)
+	}
+	if w := createRoom(alice, bob); w.Code != http.StatusForbidden {
+		t.Errorf("blocker creating a room with the blocked user = %d, want 403", w.Code)
+	}
+
+	a := connectTestClient(t, alice)
+	b := connectTestClient(t, bob)
+	c := connectTestClient(t, carol)
+
+	// Blocks made after both joined still keep the room's traffic apart
+	roomID := strconv.Itoa(room.ID)
+	dispatch(b, []byte(`{"type":"room_message","content":{"room_id":`+roomID+`,"content":"hello"}}`))
+	var messageID int
+	if err := db.QueryRow("SELECT id FROM chat_messages WHERE from_id = ?", bob).Scan(&messageID); err != nil {
+		t.Fatal(err)
+	}
+	dispatch(b, []byte(`{"type":"edit_message","content":{"message_id":`+strconv.Itoa(messageID)+`,"content":"edited"}}`))
+	dispatch(b, []byte(`{"type":"room_typing","content":{"room_id":`+roomID+`,"isTyping":true}}`))
+
+	if got := receivedFrames(a); len(got) != 0 {
+		t.Errorf("blocking member received %v", got)
+	}
+	var types []string
+	for _, frame := range receivedFrames(c) {
+		types = append(types, frame["type"].(string))
+	}
+	if len(types) != 3 || types[0] != "room_message" || types[1] != "message_edited" || types[2] != "room_typing" {
+		t.Errorf("other member received %v, want room_message, message_edited, room_typing", types)
+	}
+}
+
+func TestMutedAuthorsHiddenFromFeed(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	for _, author := range []int{bob, carol} {
+		_, err := db.Exec("INSERT INTO posts (user_id, title, content, category) VALUES (?, 'title', 'body', 'general')", author)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := postJSON(muteUserHandler, "/mute", map[string]int{"user_id": alice, "target_id": bob})
+	if w.Code != http.StatusOK {
+		t.Fatalf("mute = %d %s", w.Code, w.Body)
+	}
+
+	authors := func(query string) []int {
+		w := httptest.NewRecorder()
+		getPostsHandler(w, httptest.NewRequest(http.MethodGet, "/posts"+query, nil))
+		var posts []PostWithAuthor
+		json.NewDecoder(w.Body).Decode(&posts)
+		var ids []int
+		for _, p := range posts {
+			ids = append(ids, p.UserID)
+		}
+		return ids
+	}
+	if got := authors("?user_id=" + strconv.Itoa(alice)); len(got) != 1 || got[0] != carol {
+		t.Errorf("feed for alice has posts by %v, want only carol (%d)", got, carol)
+	}
+	if got := authors("?user_id=" + strconv.Itoa(carol)); len(got) != 2 {
+		t.Errorf("feed for carol has posts by %v, want bob and carol", got)
+	}
+}
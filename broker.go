@@ -0,0 +1,309 @@
+package srco
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Broker carries realtime events between server instances. Every instance
+// publishes the events it produces and delivers the events it receives from
+// other instances to its own connections.
+type Broker interface {
+	Publish(event BrokerEvent) error
+	Subscribe(handler func(BrokerEvent)) error
+	Close() error
+}
+
+// BrokerEvent is one message passed between instances
+type BrokerEvent struct {
+	// Instance that published the event
+	Node string `json:"node"`
+
+	// "user" delivers Payload to every connection of UserID;
+	// "presence" carries the publishing instance's connected users
+	Kind    string          `json:"kind"`
+	UserID  int             `json:"user_id,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// presenceSync is the payload of a "presence" event. A full sync replaces
+// everything known about the node; otherwise only the listed users change,
+// with "" meaning the user has no connections on that node any more.
+// Refresh lists users whose stored presence or status message changed.
+type presenceSync struct {
+	Full    bool           `json:"full"`
+	States  map[int]string `json:"states"`
+	Refresh []int          `json:"refresh,omitempty"`
+}
+
+type remoteNode struct {
+	states   map[int]string
+	lastSeen time.Time
+}
+
+func newNodeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// startBroker subscribes the hub to events from the other instances
+func (h *hub) startBroker() error {
+	return h.broker.Subscribe(h.handleBrokerEvent)
+}
+
+func (h *hub) handleBrokerEvent(event BrokerEvent) {
+	if event.Node == h.nodeID {
+		return
+	}
+
+	switch event.Kind {
+	case "user":
+		h.deliverToUser(event.UserID, nil, event.Payload)
+	case "presence":
+		var sync presenceSync
+		if err := json.Unmarshal(event.Payload, &sync); err != nil {
+			log.Printf("Invalid presence event from node %s: %v", event.Node, err)
+			return
+		}
+		for uid := range h.applyRemotePresence(event.Node, sync) {
+			h.notifyPresenceChanged(uid)
+		}
+		for _, uid := range sync.Refresh {
+			h.notifyPresenceChanged(uid)
+		}
+	}
+}
+
+// publishToUser forwards msg to the user's connections on other instances
+func (h *hub) publishToUser(userID int, msg interface{}) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding broker event: %v", err)
+		return
+	}
+	err = h.broker.Publish(BrokerEvent{Node: h.nodeID, Kind: "user", UserID: userID, Payload: payload})
+	if err != nil {
+		log.Printf("Error publishing broker event: %v", err)
+	}
+}
+
+// publishPresence tells other instances which users are connected here
+func (h *hub) publishPresence(sync presenceSync) {
+	payload, err := json.Marshal(sync)
+	if err != nil {
+		log.Printf("Error encoding presence event: %v", err)
+		return
+	}
+	if err := h.broker.Publish(BrokerEvent{Node: h.nodeID, Kind: "presence", Payload: payload}); err != nil {
+		log.Printf("Error publishing presence event: %v", err)
+	}
+}
+
+// publishPresenceRefresh tells other instances the user changed their presence settings
+func (h *hub) publishPresenceRefresh(userID int) {
+	h.publishPresence(presenceSync{Refresh: []int{userID}})
+}
+
+// applyRemotePresence records what another node reported and returns the users
+// whose state on that node changed
+func (h *hub) applyRemotePresence(node string, sync presenceSync) map[int]bool {
+	h.remote.Lock()
+	defer h.remote.Unlock()
+
+	rn, ok := h.remote.nodes[node]
+	if !ok {
+		rn = &remoteNode{states: make(map[int]string)}
+		h.remote.nodes[node] = rn
+	}
+	rn.lastSeen = time.Now()
+
+	changed := make(map[int]bool)
+	if sync.Full {
+		for uid := range rn.states {
+			if _, still := sync.States[uid]; !still {
+				delete(rn.states, uid)
+				changed[uid] = true
+			}
+		}
+	}
+	for uid, state := range sync.States {
+		if rn.states[uid] == state {
+			continue
+		}
+		if state == "" {
+			delete(rn.states, uid)
+		} else {
+			rn.states[uid] = state
+		}
+		changed[uid] = true
+	}
+	return changed
+}
+
+// mergeRemotePresence adds users connected to other live instances to
+// connected, preferring "online" over "idle". Nodes that have not sent a full
+// sync recently are assumed gone and their users are dropped.
+func (h *hub) mergeRemotePresence(connected map[int]string) {
+	cutoff := time.Now().Add(-3 * presenceCheckInterval)
+
+	h.remote.Lock()
+	defer h.remote.Unlock()
+
+	for node, rn := range h.remote.nodes {
+		if rn.lastSeen.Before(cutoff) {
+			delete(h.remote.nodes, node)
+			for uid := range rn.states {
+				h.notifyPresenceChanged(uid)
+			}
+			continue
+		}
+		for uid, state := range rn.states {
+			if connected[uid] != "online" {
+				connected[uid] = state
+			}
+		}
+	}
+}
+
+// MemoryBroker passes events between subscribers in the same process. It is
+// what a single instance uses; hubs sharing one behave like separate instances.
+type MemoryBroker struct {
+	sync.RWMutex
+	handlers []func(BrokerEvent)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(event BrokerEvent) error {
+	b.RLock()
+	handlers := append([]func(BrokerEvent){}, b.handlers...)
+	b.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler func(BrokerEvent)) error {
+	b.Lock()
+	b.handlers = append(b.handlers, handler)
+	b.Unlock()
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.Lock()
+	b.handlers = nil
+	b.Unlock()
+	return nil
+}
+
+// SQLiteBroker passes events through a table in a database shared by all
+// instances. Each subscriber polls for rows newer than the last one it saw.
+type SQLiteBroker struct {
+	db           *sql.DB
+	pollInterval time.Duration
+	retention    time.Duration
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func NewSQLiteBroker(db *sql.DB, pollInterval time.Duration) (*SQLiteBroker, error) {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS broker_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        payload TEXT,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteBroker{
+		db:           db,
+		pollInterval: pollInterval,
+		retention:    time.Minute,
+		done:         make(chan struct{}),
+	}, nil
+}
+
+func (b *SQLiteBroker) Publish(event BrokerEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("INSERT INTO broker_events (payload) VALUES (?)", string(payload))
+	return err
+}
+
+// Subscribe starts delivering events published from now on to handler
+func (b *SQLiteBroker) Subscribe(handler func(BrokerEvent)) error {
+	var lastID int64
+	if err := b.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM broker_events").Scan(&lastID); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(b.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-ticker.C:
+				lastID = b.poll(lastID, handler)
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *SQLiteBroker) poll(lastID int64, handler func(BrokerEvent)) int64 {
+	rows, err := b.db.Query("SELECT id, payload FROM broker_events WHERE id > ? ORDER BY id", lastID)
+	if err != nil {
+		log.Printf("Error polling broker events: %v", err)
+		return lastID
+	}
+
+	var events []BrokerEvent
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&lastID, &payload); err != nil {
+			continue
+		}
+		var event BrokerEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			log.Printf("Invalid broker event %d: %v", lastID, err)
+			continue
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+
+	for _, event := range events {
+		handler(event)
+	}
+
+	// Every subscriber trims old rows; whoever gets there first does the work
+	_, err = b.db.Exec("DELETE FROM broker_events WHERE created_at < datetime('now', ?)", sqliteAgo(b.retention))
+	if err != nil {
+		log.Printf("Error trimming broker events: %v", err)
+	}
+	return lastID
+}
+
+func (b *SQLiteBroker) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
@@ -0,0 +1,114 @@
+package srco
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Two hubs sharing a broker act as two server instances: events for a user
+// connected to one must reach them when produced on the other
+func TestBrokerConnectsHubs(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		openTestDB(t)
+		broker := NewMemoryBroker()
+		testTwoNodes(t, broker, broker)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		openTestDB(t)
+		var brokers [2]*SQLiteBroker
+		for i := range brokers {
+			b, err := NewSQLiteBroker(db, 10*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { b.Close() })
+			brokers[i] = b
+		}
+		testTwoNodes(t, brokers[0], brokers[1])
+	})
+}
+
+func testTwoNodes(t *testing.T, brokerA, brokerB Broker) {
+	previousDelay := presenceBatchDelay
+	presenceBatchDelay = 10 * time.Millisecond
+	t.Cleanup(func() { presenceBatchDelay = previousDelay })
+
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	nodeA := startTestNode(t, brokerA)
+	nodeB := startTestNode(t, brokerB)
+
+	a := newClient(nodeA, alice, nil)
+	nodeA.connect(a)
+	t.Cleanup(func() { nodeA.disconnect(a) })
+
+	b := newClient(nodeB, bob, nil)
+	nodeB.connect(b)
+
+	waitForFrame(t, a, "bob online on node B", presenceEvent("user_online", bob))
+
+	dispatch(a, []byte(`{"type":"chat_message","content":{"to":`+strconv.Itoa(bob)+`,"content":"hi bob"}}`))
+	waitForFrame(t, b, "chat message from node A", func(frame map[string]interface{}) bool {
+		message, _ := frame["message"].(map[string]interface{})
+		return frame["type"] == "chat_message" && message["content"] == "hi bob"
+	})
+
+	dispatch(b, []byte(`{"type":"typing_status","content":{"to":`+strconv.Itoa(alice)+`,"isTyping":true}}`))
+	waitForFrame(t, a, "typing status from node B", func(frame map[string]interface{}) bool {
+		return frame["type"] == "typing_status" && frame["from_id"] == float64(bob) && frame["is_typing"] == true
+	})
+
+	nodeB.disconnect(b)
+	waitForFrame(t, a, "bob offline after leaving node B", presenceEvent("user_offline", bob))
+}
+
+// startTestNode creates a hub subscribed to broker, stopped at the end of the test
+func startTestNode(t *testing.T, broker Broker) *hub {
+	t.Helper()
+
+	h := newHub(broker)
+	if err := h.startBroker(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.stop)
+	return h
+}
+
+// presenceEvent matches a presence_updates frame with an event of the given type for userID
+func presenceEvent(eventType string, userID int) func(map[string]interface{}) bool {
+	return func(frame map[string]interface{}) bool {
+		events, _ := frame["events"].([]interface{})
+		for _, e := range events {
+			event, _ := e.(map[string]interface{})
+			if event["type"] == eventType && event["id"] == float64(userID) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// waitForFrame reads the frames queued for c until one matches, failing the test after a second
+func waitForFrame(t *testing.T, c *client, what string, match func(map[string]interface{}) bool) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-c.send:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var frame map[string]interface{}
+			json.Unmarshal(data, &frame)
+			if match(frame) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package srco
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 func getUserStatsHandler(w http.ResponseWriter, r *http.Request) {
@@ -22,7 +24,7 @@ func getUserStatsHandler(w http.ResponseWriter, r *http.Request) {
 	err := db.QueryRow(`
 		SELECT COUNT(*) 
 		FROM posts 
-		WHERE user_id = ?`, userID).Scan(&stats.PostCount)
+		WHERE user_id = ? AND deleted_at IS NULL AND status = 'published'`, userID).Scan(&stats.PostCount)
 	if err != nil {
 		log.Printf("Error counting posts: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -33,7 +35,7 @@ func getUserStatsHandler(w http.ResponseWriter, r *http.Request) {
 	err = db.QueryRow(`
 		SELECT COUNT(*) 
 		FROM likes_dislikes 
-		WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?) 
+		WHERE post_id IN (SELECT id FROM posts WHERE user_id = ? AND deleted_at IS NULL AND status = 'published') 
 		AND is_like = 1`, userID).Scan(&stats.LikesReceived)
 	if err != nil {
 		log.Printf("Error counting likes: %v", err)
@@ -45,109 +47,256 @@ func getUserStatsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
-// Update broadcastOnlineUsers function
-func broadcastOnlineUsers() {
-	onlineUsers.RLock()
-	defer onlineUsers.RUnlock()
+type userListEntry struct {
+	id                 int
+	nickname           string
+	presence           string
+	statusMessage      string
+	lastSeen           string
+	lastSeenVisibility string
+}
 
-	// Get all users from database
-	rows, err := db.Query("SELECT id, nickname FROM users ORDER BY nickname")
+// sendUserList sends the user list to a single client
+func sendUserList(c *client) {
+	users, err := fetchUserList()
 	if err != nil {
 		log.Printf("Error fetching users: %v", err)
 		return
 	}
+
+	c.enqueue(userListMessage(c.userID, users, c.hub.connectedPresence()))
+}
+
+func fetchUserList() ([]userListEntry, error) {
+	// Get all users from database
+	rows, err := db.Query(`
+		SELECT id, nickname, COALESCE(presence, 'online'), COALESCE(status_message, ''),
+		       COALESCE(last_seen, ''), COALESCE(last_seen_visibility, 'everyone')
+		FROM users 
+		ORDER BY nickname`)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	var allUsers []map[string]interface{}
+	var users []userListEntry
 	for rows.Next() {
-		var id int
-		var nickname string
-		if err := rows.Scan(&id, &nickname); err != nil {
+		var u userListEntry
+		if err := rows.Scan(&u.id, &u.nickname, &u.presence, &u.statusMessage,
+			&u.lastSeen, &u.lastSeenVisibility); err != nil {
 			continue
 		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
 
-		// Check if user is online
-		_, isOnline := onlineUsers.users[id]
+// userListMessage builds the user list as seen by viewerID
+func userListMessage(viewerID int, users []userListEntry, connected map[int]string) map[string]interface{} {
+	unread := unreadCounts(viewerID)
+	contacts := chatContacts(viewerID)
 
-		allUsers = append(allUsers, map[string]interface{}{
-			"id":       id,
-			"nickname": nickname,
-			"status":   isOnline,
-		})
+	allUsers := make([]map[string]interface{}, 0, len(users))
+	for _, u := range users {
+		presence := effectivePresence(u, connected)
+		entry := map[string]interface{}{
+			"id":       u.id,
+			"nickname": u.nickname,
+			"status":   presence != "offline",
+			"presence": presence,
+			"unread":   unread[u.id],
+		}
+		if u.presence != "invisible" || u.id == viewerID {
+			entry["status_message"] = u.statusMessage
+		}
+		if presence == "offline" && u.lastSeen != "" && lastSeenVisibleTo(u, viewerID, contacts) {
+			entry["last_seen"] = u.lastSeen
+		}
+		allUsers = append(allUsers, entry)
 	}
 
-	message := map[string]interface{}{
+	return map[string]interface{}{
 		"type":  "userList",
 		"users": allUsers,
 	}
-
-	// Send updated user list to all connected users
-	for _, conn := range onlineUsers.users {
-		if err := conn.WriteJSON(message); err != nil {
-			log.Printf("Error sending user list: %v", err)
-		}
-	}
 }
 
-// Update getChatHistoryHandler to support pagination
+// getChatHistoryHandler pages through a private conversation by message ID.
+// Without a cursor it returns the latest messages; "before" pages back in
+// history and "after" fetches newer messages. Messages are newest first.
 func getChatHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	userID1 := r.URL.Query().Get("user1")
 	userID2 := r.URL.Query().Get("user2")
-	offset := r.URL.Query().Get("offset")
-	limit := r.URL.Query().Get("limit")
 
-	// Set default values if not provided
-	if offset == "" {
-		offset = "0"
+	before, after, limit, err := parseHistoryCursor(r)
+	if err != nil {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	messages, hasMore, err := fetchMessagePage(
+		"(cm.from_id = ? AND cm.to_id = ?) OR (cm.from_id = ? AND cm.to_id = ?)",
+		[]interface{}{userID1, userID2, userID2, userID1},
+		before, after, limit)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
-	if limit == "" {
-		limit = "10"
+
+	response := map[string]interface{}{
+		"messages": messages,
+		"has_more": hasMore,
 	}
 
-	// Get chat messages with pagination
-	rows, err := db.Query(`
-		SELECT cm.from_id, cm.to_id, cm.content, cm.created_at, u.nickname as from_nick
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+const (
+	defaultHistoryPageSize = 10
+	maxHistoryPageSize     = 100
+)
+
+func parseHistoryCursor(r *http.Request) (before, after, limit int, err error) {
+	query := r.URL.Query()
+	limit = defaultHistoryPageSize
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > maxHistoryPageSize {
+			limit = maxHistoryPageSize
+		}
+	}
+	if v := query.Get("before"); v != "" {
+		if before, err = strconv.Atoi(v); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	if v := query.Get("after"); v != "" {
+		if after, err = strconv.Atoi(v); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return before, after, limit, nil
+}
+
+// fetchMessagePage returns up to limit messages matching filter, newest first,
+// and whether more exist beyond the page. One extra row is fetched to answer
+// that instead of counting. If after is set it takes precedence over before.
+func fetchMessagePage(filter string, args []interface{}, before, after, limit int) ([]ChatMessage, bool, error) {
+	query := `
+		SELECT cm.id, cm.from_id, COALESCE(cm.to_id, 0), COALESCE(cm.room_id, 0), cm.content, cm.created_at,
+		       u.nickname as from_nick, COALESCE(cm.edited_at, '') as edited_at, cm.deleted_at IS NOT NULL as deleted
 		FROM chat_messages cm
 		JOIN users u ON cm.from_id = u.id
-		WHERE (cm.from_id = ? AND cm.to_id = ?) OR (cm.from_id = ? AND cm.to_id = ?)
-		ORDER BY cm.created_at DESC
-		LIMIT ? OFFSET ?`,
-		userID1, userID2, userID2, userID1, limit, offset)
+		WHERE (` + filter + `)`
+
+	order := "DESC"
+	if after > 0 {
+		query += " AND cm.id > ?"
+		args = append(args, after)
+		order = "ASC"
+	} else if before > 0 {
+		query += " AND cm.id < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY cm.id " + order + " LIMIT ?"
+	args = append(args, limit+1)
 
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+		return nil, false, err
 	}
 	defer rows.Close()
 
-	var messages []ChatMessage
+	messages := []ChatMessage{}
 	for rows.Next() {
 		var msg ChatMessage
-		err := rows.Scan(&msg.FromID, &msg.ToID, &msg.Content, &msg.Timestamp, &msg.FromNick)
+		err := rows.Scan(&msg.ID, &msg.FromID, &msg.ToID, &msg.RoomID, &msg.Content, &msg.Timestamp,
+			&msg.FromNick, &msg.EditedAt, &msg.Deleted)
 		if err != nil {
 			continue
 		}
 		messages = append(messages, msg)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
 
-	// Get total count of messages
-	var totalCount int
-	err = db.QueryRow(`
-		SELECT COUNT(*) 
-		FROM chat_messages 
-		WHERE (from_id = ? AND to_id = ?) OR (from_id = ? AND to_id = ?)`,
-		userID1, userID2, userID2, userID1).Scan(&totalCount)
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	loadChatAttachments(messages)
+
+	// Messages after the cursor were read oldest first
+	if order == "ASC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, hasMore, nil
+}
+
+// Longest last message preview returned by getConversationsHandler, in characters
+const conversationSnippetLength = 80
 
+func getConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
 	if err != nil {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Message IDs only grow, so the highest ID per partner is the latest activity.
+	// Each half of the union is served by one of the chat_messages indexes.
+	rows, err := db.Query(`
+		WITH last AS (
+			SELECT partner_id, MAX(id) as last_id
+			FROM (
+				SELECT to_id as partner_id, id FROM chat_messages WHERE from_id = ?
+				UNION ALL
+				SELECT from_id as partner_id, id FROM chat_messages WHERE to_id = ?
+			)
+			GROUP BY partner_id
+		)
+		SELECT last.partner_id, u.nickname, cm.id, cm.from_id, cm.content, cm.created_at
+		FROM last
+		JOIN chat_messages cm ON cm.id = last.last_id
+		JOIN users u ON u.id = last.partner_id
+		ORDER BY cm.id DESC`, userID, userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	defer rows.Close()
 
-	response := map[string]interface{}{
-		"messages": messages,
-		"total":    totalCount,
+	unread := unreadCounts(userID)
+	conversations := []Conversation{}
+	for rows.Next() {
+		var conv Conversation
+		if err := rows.Scan(&conv.PartnerID, &conv.PartnerNickname, &conv.LastMessageID,
+			&conv.LastMessageFromID, &conv.LastMessage, &conv.LastMessageAt); err != nil {
+			log.Printf("Error scanning conversation: %v", err)
+			continue
+		}
+		conv.LastMessage = snippet(conv.LastMessage, conversationSnippetLength)
+		conv.Unread = unread[conv.PartnerID]
+		conversations = append(conversations, conv)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// snippet shortens s to at most n characters, marking the cut with an ellipsis
+func snippet(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
 }
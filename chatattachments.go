@@ -0,0 +1,271 @@
+package srco
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Longest side, in pixels, of thumbnails generated for images sent in chat
+var chatThumbnailSize = 320
+
+// uploadChatAttachmentHandler stores a file that can then be sent by referencing
+// its ID in a chat_message or room_message frame. Until then only the uploader
+// can access it.
+func uploadChatAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	userID, _ := strconv.Atoi(r.FormValue("user_id"))
+	var exists int
+	if err := db.QueryRow("SELECT id FROM users WHERE id = ?", userID).Scan(&exists); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	attachment, status, err := storeAttachment(file, header.Filename, header.Size)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		attachment.thumbnailKey = makeThumbnail(attachment.blobKey)
+	}
+
+	if status, err := insertAttachment(userID, 0, attachment); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	setChatAttachmentURLs(attachment)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// downloadChatAttachmentHandler serves a chat attachment, or its thumbnail with
+// thumb=1, to the uploader and the participants of the conversation it was sent in.
+// Attachments of deleted messages are not served.
+func downloadChatAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	attachmentID := r.URL.Query().Get("id")
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	var attachment Attachment
+	var thumbnailKey sql.NullString
+	err := db.QueryRow(`
+        SELECT a.filename, a.content_type, a.size, a.blob_key, a.thumbnail_key
+        FROM attachments a
+        LEFT JOIN chat_messages cm ON cm.id = a.message_id
+        WHERE a.id = ? AND a.post_id IS NULL AND cm.deleted_at IS NULL
+        AND (a.user_id = ? OR cm.to_id = ? OR cm.from_id = ?
+             OR cm.room_id IN (SELECT room_id FROM room_members WHERE user_id = ?))`,
+		attachmentID, userID, userID, userID, userID).Scan(
+		&attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.blobKey, &thumbnailKey)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("thumb") == "1" {
+		if !thumbnailKey.Valid {
+			http.Error(w, "Attachment has no thumbnail", http.StatusNotFound)
+			return
+		}
+		serveBlob(w, r, thumbnailKey.String, "image/png", "thumbnail-"+attachment.Filename+".png", 0)
+		return
+	}
+
+	serveBlob(w, r, attachment.blobKey, attachment.ContentType, attachment.Filename, attachment.Size)
+}
+
+// storeChatMessage inserts a private message, or a room message if RoomID is
+// set, and sets its ID. An attachment is linked in the same transaction; it must
+// have been uploaded by the sender and not sent yet, otherwise nothing is stored
+// and errInvalidAttachment is returned.
+func storeChatMessage(message *ChatMessage, attachmentID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Room messages have no to_id, which keeps them out of private conversations
+	var toID, roomID interface{}
+	if message.RoomID != 0 {
+		roomID = message.RoomID
+	} else {
+		toID = message.ToID
+	}
+	result, err := tx.Exec(`
+		INSERT INTO chat_messages (from_id, to_id, room_id, content, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		message.FromID, toID, roomID, message.Content)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+
+	if attachmentID != 0 {
+		result, err := tx.Exec(`
+			UPDATE attachments SET message_id = ?
+			WHERE id = ? AND user_id = ? AND post_id IS NULL AND message_id IS NULL`,
+			id, attachmentID, message.FromID)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n != 1 {
+			return errInvalidAttachment
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	message.ID = int(id)
+
+	if attachmentID != 0 {
+		messages := []ChatMessage{*message}
+		loadChatAttachments(messages)
+		message.Attachment = messages[0].Attachment
+	}
+	return nil
+}
+
+// loadChatAttachments fills in the attachment of each message that has one
+func loadChatAttachments(messages []ChatMessage) {
+	if len(messages) == 0 {
+		return
+	}
+
+	index := make(map[int]int, len(messages))
+	placeholders := make([]string, len(messages))
+	args := make([]interface{}, len(messages))
+	for i, msg := range messages {
+		index[msg.ID] = i
+		placeholders[i] = "?"
+		args[i] = msg.ID
+	}
+
+	rows, err := db.Query(`
+		SELECT id, message_id, filename, content_type, size, created_at, COALESCE(thumbnail_key, '')
+		FROM attachments
+		WHERE message_id IN (`+strings.Join(placeholders, ",")+`)`, args...)
+	if err != nil {
+		log.Printf("Error fetching chat attachments: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attachment Attachment
+		if err := rows.Scan(&attachment.ID, &attachment.MessageID, &attachment.Filename,
+			&attachment.ContentType, &attachment.Size, &attachment.CreatedAt, &attachment.thumbnailKey); err != nil {
+			log.Printf("Error scanning attachment: %v", err)
+			continue
+		}
+		setChatAttachmentURLs(&attachment)
+		if i, ok := index[attachment.MessageID]; ok && !messages[i].Deleted {
+			messages[i].Attachment = &attachment
+		}
+	}
+}
+
+func setChatAttachmentURLs(attachment *Attachment) {
+	attachment.URL = fmt.Sprintf("/chat/attachment?id=%d", attachment.ID)
+	if attachment.thumbnailKey != "" {
+		attachment.ThumbnailURL = attachment.URL + "&thumb=1"
+	}
+}
+
+// makeThumbnail stores a scaled-down PNG of the image blob and returns its key,
+// or "" if the image cannot be decoded
+func makeThumbnail(blobKey string) string {
+	blob, err := blobStore.Get(blobKey)
+	if err != nil {
+		log.Printf("Blob store error: %v", err)
+		return ""
+	}
+	defer blob.Close()
+
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(blob); err != nil {
+		return ""
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data.Bytes()))
+	if err != nil || config.Width*config.Height > maxAvatarPixels {
+		return ""
+	}
+	img, _, err := image.Decode(bytes.NewReader(data.Bytes()))
+	if err != nil {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, resizeToFit(img, chatThumbnailSize)); err != nil {
+		return ""
+	}
+
+	key := blobKey + "-thumb"
+	if err := blobStore.Put(key, &buf); err != nil {
+		log.Printf("Blob store error: %v", err)
+		return ""
+	}
+	return key
+}
+
+// resizeToFit scales src down so its longest side is at most size, keeping the aspect ratio
+func resizeToFit(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	if w >= h {
+		h = h * size / w
+		w = size
+	} else {
+		w = w * size / h
+		h = size
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,72 @@
+package srco
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// An attachment goes out with exactly one message, and a send that cannot
+// link it stores nothing and is not acknowledged
+func TestChatAttachmentSentOnce(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	result, err := db.Exec(`
+		INSERT INTO attachments (user_id, filename, content_type, size, blob_key)
+		VALUES (?, 'a.txt', 'text/plain', 4, 'file')`, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentID, _ := result.LastInsertId()
+	frame := func(to int) []byte {
+		return []byte(`{"type":"chat_message","content":{"to":` + strconv.Itoa(to) +
+			`,"content":"see file","attachment_id":` + strconv.FormatInt(attachmentID, 10) + `}}`)
+	}
+	countMessages := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM chat_messages").Scan(&n)
+		return n
+	}
+
+	// Someone else's attachment cannot be sent
+	b := connectTestClient(t, bob)
+	dispatch(b, frame(alice))
+	if codes := errorCodes(b); len(codes) != 1 || codes[0] != "invalid_attachment" {
+		t.Fatalf("sending another user's attachment: errors = %v, want [invalid_attachment]", codes)
+	}
+	if n := countMessages(); n != 0 {
+		t.Fatalf("%d messages stored for a refused send", n)
+	}
+
+	// Two devices sending the same attachment at once
+	devices := []*client{connectTestClient(t, alice), connectTestClient(t, alice)}
+	var wg sync.WaitGroup
+	for _, c := range devices {
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			dispatch(c, frame(bob))
+		}(c)
+	}
+	wg.Wait()
+
+	var acks, refused int
+	for _, c := range devices {
+		for _, frame := range receivedFrames(c) {
+			switch {
+			case frame["type"] == "chat_ack":
+				acks++
+			case frame["type"] == "error" && frame["code"] == "invalid_attachment":
+				refused++
+			}
+		}
+	}
+	if acks != 1 || refused != 1 {
+		t.Errorf("acks = %d, refused = %d, want one of each", acks, refused)
+	}
+	if n := countMessages(); n != 1 {
+		t.Errorf("%d messages stored, want 1", n)
+	}
+}
@@ -0,0 +1,94 @@
+package srco
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Number of outbound messages buffered per connection before the client counts as too slow
+var clientSendBuffer = 64
+
+// client wraps a WebSocket connection. gorilla/websocket supports only one
+// concurrent writer, so all writes go through send and are performed by writePump.
+// Clients on the HTTP transports have no conn; their handlers drain send instead.
+type client struct {
+	// Unix nanoseconds of the last frame received, accessed atomically.
+	// Kept first so it is 64-bit aligned on 32-bit platforms.
+	lastActive int64
+
+	hub       *hub
+	userID    int
+	conn      *websocket.Conn
+	send      chan interface{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(h *hub, userID int, conn *websocket.Conn) *client {
+	return &client{
+		hub:        h,
+		userID:     userID,
+		conn:       conn,
+		send:       make(chan interface{}, clientSendBuffer),
+		done:       make(chan struct{}),
+		lastActive: time.Now().UnixNano(),
+	}
+}
+
+// enqueue queues msg for the write pump without blocking. A client whose buffer
+// is full cannot keep up and is disconnected rather than stalling the sender.
+func (c *client) enqueue(msg interface{}) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		log.Printf("Disconnecting slow client for user %d", c.userID)
+		c.close()
+		return false
+	}
+}
+
+// close stops the write pump and closes the connection, which also ends the read loop
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
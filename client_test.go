@@ -0,0 +1,151 @@
+package srco
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestConn returns both ends of a WebSocket: the server's, for a client, and the peer's
+func newTestConn(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return <-conns, peer
+}
+
+// The write pump delivers frames queued from many goroutines, in order per goroutine
+func TestClientWritePump(t *testing.T) {
+	conn, peer := newTestConn(t)
+	c := newClient(defaultHub, 1, conn)
+	go c.writePump()
+	defer c.close()
+
+	const senders, perSender = 4, 10
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for i := 0; i < perSender; i++ {
+				c.enqueue(map[string]int{"sender": s, "seq": i})
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	next := make([]int, senders)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for n := 0; n < senders*perSender; n++ {
+		var frame map[string]int
+		if err := peer.ReadJSON(&frame); err != nil {
+			t.Fatalf("reading frame %d: %v", n, err)
+		}
+		if frame["seq"] != next[frame["sender"]] {
+			t.Fatalf("sender %d: got seq %d, want %d", frame["sender"], frame["seq"], next[frame["sender"]])
+		}
+		next[frame["sender"]]++
+	}
+}
+
+// enqueue, close and the write pump may run at the same time without racing or panicking
+func TestClientConcurrentEnqueueAndClose(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		conn, peer := newTestConn(t)
+		go func() {
+			for {
+				if _, _, err := peer.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		c := newClient(defaultHub, 1, conn)
+		pumpDone := make(chan struct{})
+		go func() {
+			c.writePump()
+			close(pumpDone)
+		}()
+
+		var wg sync.WaitGroup
+		for s := 0; s < 8; s++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					c.enqueue(map[string]int{"seq": i})
+				}
+			}()
+		}
+		for s := 0; s < 2; s++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				time.Sleep(time.Millisecond)
+				c.close()
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-pumpDone:
+		case <-time.After(5 * time.Second):
+			t.Fatal("write pump still running after close")
+		}
+		if c.enqueue("late") {
+			t.Fatal("enqueue accepted a frame after close")
+		}
+	}
+}
+
+// A client that does not drain its buffer is disconnected instead of blocking the sender
+func TestSlowClientIsDisconnected(t *testing.T) {
+	conn, _ := newTestConn(t)
+	c := newClient(defaultHub, 1, conn)
+
+	for i := 0; i < clientSendBuffer; i++ {
+		if !c.enqueue(i) {
+			t.Fatalf("frame %d rejected before the buffer was full", i)
+		}
+	}
+
+	sent := make(chan bool)
+	go func() { sent <- c.enqueue("overflow") }()
+	select {
+	case ok := <-sent:
+		if ok {
+			t.Fatal("frame past the buffer was accepted")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("enqueue blocked on a full buffer")
+	}
+
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("slow client was not closed")
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("{}")); err == nil {
+		t.Error("connection of slow client still open")
+	}
+}
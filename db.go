@@ -1,6 +1,11 @@
 package srco
 
-import "log"
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
 
 func createTables() {
 	createUsersTable := `
@@ -60,10 +65,244 @@ func createTables() {
         FOREIGN KEY(to_id) REFERENCES users(id)
     );`
 
-	tables := []string{createUsersTable, createPostsTable, createLikesDislikesTable, createCommentsTable, createChatMessagesTable}
+	createAttachmentsTable := `
+    CREATE TABLE IF NOT EXISTS attachments (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        post_id INTEGER,
+        user_id INTEGER,
+        filename TEXT,
+        content_type TEXT,
+        size INTEGER,
+        blob_key TEXT UNIQUE,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY(post_id) REFERENCES posts(id),
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    );`
+
+	createChatReadMarkersTable := `
+    CREATE TABLE IF NOT EXISTS chat_read_markers (
+        user_id INTEGER,
+        partner_id INTEGER,
+        last_read_id INTEGER DEFAULT 0,
+        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY(user_id, partner_id),
+        FOREIGN KEY(user_id) REFERENCES users(id),
+        FOREIGN KEY(partner_id) REFERENCES users(id)
+    );`
+
+	createRoomsTable := `
+    CREATE TABLE IF NOT EXISTS rooms (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT,
+        owner_id INTEGER,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY(owner_id) REFERENCES users(id)
+    );`
+
+	createRoomMembersTable := `
+    CREATE TABLE IF NOT EXISTS room_members (
+        room_id INTEGER,
+        user_id INTEGER,
+        role TEXT DEFAULT 'member',
+        joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY(room_id, user_id),
+        FOREIGN KEY(room_id) REFERENCES rooms(id),
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    );`
+
+	createUserBlocksTable := `
+    CREATE TABLE IF NOT EXISTS user_blocks (
+        user_id INTEGER,
+        blocked_id INTEGER,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY(user_id, blocked_id),
+        FOREIGN KEY(user_id) REFERENCES users(id),
+        FOREIGN KEY(blocked_id) REFERENCES users(id)
+    );`
+
+	createUserMutesTable := `
+    CREATE TABLE IF NOT EXISTS user_mutes (
+        user_id INTEGER,
+        muted_id INTEGER,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY(user_id, muted_id),
+        FOREIGN KEY(user_id) REFERENCES users(id),
+        FOREIGN KEY(muted_id) REFERENCES users(id)
+    );`
+
+	// Per-conversation retention for private chats, user1_id being the lower ID
+	createChatRetentionTable := `
+    CREATE TABLE IF NOT EXISTS chat_retention (
+        user1_id INTEGER,
+        user2_id INTEGER,
+        retention_days INTEGER,
+        updated_by INTEGER,
+        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY(user1_id, user2_id),
+        FOREIGN KEY(user1_id) REFERENCES users(id),
+        FOREIGN KEY(user2_id) REFERENCES users(id)
+    );`
+
+	tables := []string{createUsersTable, createPostsTable, createLikesDislikesTable, createCommentsTable, createChatMessagesTable,
+		createAttachmentsTable, createChatReadMarkersTable, createRoomsTable, createRoomMembersTable,
+		createUserBlocksTable, createUserMutesTable, createChatRetentionTable}
 	for _, query := range tables {
 		if _, err := db.Exec(query); err != nil {
 			log.Fatal("Could not create table:", err)
 		}
 	}
+
+	// Columns added after the initial schema, applied to existing databases as well
+	columns := []struct{ table, column, definition string }{
+		{"users", "role", "TEXT DEFAULT 'user'"},
+		{"posts", "deleted_at", "TIMESTAMP"},
+		{"posts", "deleted_by", "INTEGER"},
+		{"comments", "deleted_at", "TIMESTAMP"},
+		{"comments", "deleted_by", "INTEGER"},
+		{"posts", "status", "TEXT DEFAULT 'published'"},
+		{"posts", "publish_at", "TIMESTAMP"},
+		{"posts", "updated_at", "TIMESTAMP"},
+		{"users", "avatar_key", "TEXT"},
+		{"chat_messages", "delivered_at", "TIMESTAMP"},
+		{"chat_messages", "read_at", "TIMESTAMP"},
+		{"chat_messages", "room_id", "INTEGER"},
+		{"chat_messages", "edited_at", "TIMESTAMP"},
+		{"chat_messages", "deleted_at", "TIMESTAMP"},
+		{"attachments", "message_id", "INTEGER"},
+		{"attachments", "thumbnail_key", "TEXT"},
+		{"users", "presence", "TEXT DEFAULT 'online'"},
+		{"users", "status_message", "TEXT"},
+		{"users", "last_seen", "TIMESTAMP"},
+		{"users", "last_seen_visibility", "TEXT DEFAULT 'everyone'"},
+		{"rooms", "retention_days", "INTEGER"},
+	}
+	for _, c := range columns {
+		addColumnIfMissing(c.table, c.column, c.definition)
+	}
+
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_chat_messages_from_to ON chat_messages(from_id, to_id, id)",
+		"CREATE INDEX IF NOT EXISTS idx_chat_messages_to_from ON chat_messages(to_id, from_id, id)",
+		"CREATE INDEX IF NOT EXISTS idx_chat_messages_room ON chat_messages(room_id, id)",
+		"CREATE INDEX IF NOT EXISTS idx_room_members_user ON room_members(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments(message_id)",
+		"CREATE INDEX IF NOT EXISTS idx_chat_messages_created ON chat_messages(created_at)",
+	}
+	for _, query := range indexes {
+		if _, err := db.Exec(query); err != nil {
+			log.Fatal("Could not create index:", err)
+		}
+	}
+
+	createChatSearchIndex()
+}
+
+// Triggers that keep chat_messages_fts in sync with chat_messages
+var chatSearchTriggers = []string{"chat_messages_fts_insert", "chat_messages_fts_delete", "chat_messages_fts_update"}
+
+// createChatSearchIndex sets up the FTS5 index over chat message content, kept
+// in sync by triggers. It needs SQLite built with FTS5 (the sqlite_fts5 build tag
+// for go-sqlite3); without it search is disabled and the server still starts.
+func createChatSearchIndex() {
+	var existing, triggers int
+	err := db.QueryRow(`
+		SELECT COUNT(CASE WHEN type = 'table' AND name = 'chat_messages_fts' THEN 1 END),
+		       COUNT(CASE WHEN type = 'trigger' AND name LIKE 'chat_messages_fts_%' THEN 1 END)
+		FROM sqlite_master`).Scan(&existing, &triggers)
+	if err != nil {
+		log.Fatal("Could not inspect schema:", err)
+	}
+
+	if err := probeFTS5(); err != nil {
+		log.Printf("SQLite has no FTS5 support, chat search is disabled: %v", err)
+		chatSearchEnabled = false
+
+		// Triggers left by an FTS5-enabled build would make every chat insert fail
+		for _, name := range chatSearchTriggers {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				log.Fatal("Could not drop search trigger:", err)
+			}
+		}
+		return
+	}
+
+	queries := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS chat_messages_fts
+         USING fts5(content, content='chat_messages', content_rowid='id')`,
+		`CREATE TRIGGER IF NOT EXISTS chat_messages_fts_insert AFTER INSERT ON chat_messages BEGIN
+            INSERT INTO chat_messages_fts(rowid, content) VALUES (new.id, new.content);
+         END`,
+		`CREATE TRIGGER IF NOT EXISTS chat_messages_fts_delete AFTER DELETE ON chat_messages BEGIN
+            INSERT INTO chat_messages_fts(chat_messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+         END`,
+		`CREATE TRIGGER IF NOT EXISTS chat_messages_fts_update AFTER UPDATE OF content ON chat_messages BEGIN
+            INSERT INTO chat_messages_fts(chat_messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+            INSERT INTO chat_messages_fts(rowid, content) VALUES (new.id, new.content);
+         END`,
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			log.Fatal("Could not create search index:", err)
+		}
+	}
+	chatSearchEnabled = true
+
+	// Index messages stored before the index existed, or while it went unmaintained
+	if existing == 0 || triggers < len(chatSearchTriggers) {
+		if _, err := db.Exec("INSERT INTO chat_messages_fts(chat_messages_fts) VALUES ('rebuild')"); err != nil {
+			log.Fatal("Could not build search index:", err)
+		}
+	}
+}
+
+// probeFTS5 creates and drops a throwaway FTS5 table. An existing FTS5 table
+// is no proof, since it may have been created by a build that had the module.
+func probeFTS5() error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "CREATE VIRTUAL TABLE temp.fts5_probe USING fts5(content)"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "DROP TABLE temp.fts5_probe")
+	return err
+}
+
+func addColumnIfMissing(table, column, definition string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		log.Fatal("Could not inspect table:", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue interface{}
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			log.Fatal("Could not inspect table:", err)
+		}
+		if name == column {
+			return
+		}
+	}
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		log.Fatal("Could not add column:", err)
+	}
+}
+
+// sqliteAgo formats d as a datetime() modifier, e.g. datetime('now', sqliteAgo(time.Hour))
+func sqliteAgo(d time.Duration) string {
+	return fmt.Sprintf("-%d seconds", int64(d.Seconds()))
 }
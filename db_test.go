@@ -0,0 +1,108 @@
+package srco
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestDB points db at a fresh database with the full schema, and defaultHub
+// at a fresh hub, for the length of the test
+func openTestDB(t testing.TB) {
+	t.Helper()
+
+	previous := db
+	// Immediate transactions take the write lock on BEGIN, so concurrent writers
+	// wait for each other instead of failing with "database is locked"
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db")+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = conn
+	createTables()
+
+	t.Cleanup(func() {
+		conn.Close()
+		db = previous
+	})
+	useTestHub(t)
+
+	// User IDs start over in every database, so earlier tests' limits must not carry over
+	rateLimiters.Lock()
+	rateLimiters.users = make(map[int]*userLimiter)
+	rateLimiters.Unlock()
+}
+
+// useTestHub replaces defaultHub with a fresh one, stopped before the test's database closes
+func useTestHub(t testing.TB) *hub {
+	t.Helper()
+
+	previous := defaultHub
+	defaultHub = newHub(NewMemoryBroker())
+	h := defaultHub
+	t.Cleanup(func() {
+		h.stop()
+		defaultHub = previous
+	})
+	return h
+}
+
+// createTestUser inserts a user with the given nickname and returns their ID
+func createTestUser(t testing.TB, nickname string) int {
+	t.Helper()
+
+	result, err := db.Exec("INSERT INTO users (nickname, email, password) VALUES (?, ?, '')",
+		nickname, nickname+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// postJSON calls handler with body encoded as the JSON request body
+func postJSON(handler http.HandlerFunc, url string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, url, bytes.NewReader(data)))
+	return w
+}
+
+// Search works with FTS5 and answers 501 without it; chat messages are stored either way
+func TestChatSearchAvailability(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	_, err := db.Exec("INSERT INTO chat_messages (from_id, to_id, content) VALUES (?, ?, 'hello there')", alice, bob)
+	if err != nil {
+		t.Fatalf("storing a chat message: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	searchChatHandler(w, httptest.NewRequest(http.MethodGet, "/chat/search?user_id=1&q=hello", nil))
+
+	if !chatSearchEnabled {
+		if w.Code != http.StatusNotImplemented {
+			t.Fatalf("search without FTS5 = %d, want %d", w.Code, http.StatusNotImplemented)
+		}
+		return
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("search = %d %s, want 200", w.Code, w.Body)
+	}
+	var results []ChatSearchResult
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Message.Content, "hello") {
+		t.Fatalf("search results = %+v, want the one message", results)
+	}
+}
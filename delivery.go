@@ -0,0 +1,123 @@
+package srco
+
+import "log"
+
+// Most messages replayed to a client when it reconnects
+var maxReplayMessages = 500
+
+// replayPendingMessages sends c the messages addressed to its user that it has
+// not seen yet. With a last seen ID the client resumes right after it; without
+// one, every message not yet acknowledged as delivered is sent. At most
+// maxReplayMessages are sent; has_more tells the client to fetch the rest from
+// the chat history.
+//
+// c is registered with the hub before the replay so no message is missed in
+// between, which means a message can arrive both live and in the replay.
+// Clients must drop messages whose ID they have already seen.
+func replayPendingMessages(c *client, lastSeenID int) {
+	query := `
+		SELECT cm.id, cm.from_id, cm.to_id, cm.content, cm.created_at, u.nickname,
+		       COALESCE(cm.edited_at, '') as edited_at, cm.deleted_at IS NOT NULL as deleted
+		FROM chat_messages cm
+		JOIN users u ON cm.from_id = u.id
+		WHERE cm.to_id = ? AND cm.delivered_at IS NULL
+		ORDER BY cm.id
+		LIMIT ?`
+	args := []interface{}{c.userID, maxReplayMessages + 1}
+	if lastSeenID > 0 {
+		query = `
+		SELECT cm.id, cm.from_id, cm.to_id, cm.content, cm.created_at, u.nickname,
+		       COALESCE(cm.edited_at, '') as edited_at, cm.deleted_at IS NOT NULL as deleted
+		FROM chat_messages cm
+		JOIN users u ON cm.from_id = u.id
+		WHERE cm.to_id = ? AND cm.id > ?
+		ORDER BY cm.id
+		LIMIT ?`
+		args = []interface{}{c.userID, lastSeenID, maxReplayMessages + 1}
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error fetching pending messages: %v", err)
+		return
+	}
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.FromID, &msg.ToID, &msg.Content, &msg.Timestamp, &msg.FromNick,
+			&msg.EditedAt, &msg.Deleted); err != nil {
+			log.Printf("Error scanning pending message: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	rows.Close()
+
+	if len(messages) == 0 {
+		return
+	}
+	hasMore := len(messages) > maxReplayMessages
+	if hasMore {
+		messages = messages[:maxReplayMessages]
+	}
+	loadChatAttachments(messages)
+
+	// One frame for the whole backlog, which could otherwise overflow the send buffer
+	c.enqueue(map[string]interface{}{
+		"type":     "pending_messages",
+		"messages": messages,
+		"has_more": hasMore,
+	})
+}
+
+// handleMessageAck records that the recipient's client received or read a
+// message and passes the new status on to the sender
+func handleMessageAck(c *client, env *envelope) error {
+	var content messageAckContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+
+	var update string
+	switch content.Status {
+	case "delivered":
+		update = `
+			UPDATE chat_messages 
+			SET delivered_at = CURRENT_TIMESTAMP 
+			WHERE id = ? AND to_id = ? AND delivered_at IS NULL`
+	case "read":
+		update = `
+			UPDATE chat_messages 
+			SET delivered_at = COALESCE(delivered_at, CURRENT_TIMESTAMP), read_at = CURRENT_TIMESTAMP 
+			WHERE id = ? AND to_id = ? AND read_at IS NULL`
+	default:
+		return errInvalidContent
+	}
+
+	// Only the recipient can acknowledge a message
+	var fromID int
+	err := db.QueryRow("SELECT from_id FROM chat_messages WHERE id = ? AND to_id = ?",
+		content.MessageID, c.userID).Scan(&fromID)
+	if err != nil {
+		return errInvalidContent
+	}
+
+	result, err := db.Exec(update, content.MessageID, c.userID)
+	if err != nil {
+		log.Printf("Error updating message status: %v", err)
+		return errInternal
+	}
+
+	// Repeated acks, e.g. from several devices, are not passed on again
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	c.hub.sendToUser(fromID, map[string]interface{}{
+		"type":       "message_status",
+		"message_id": content.MessageID,
+		"status":     content.Status,
+	})
+	return nil
+}
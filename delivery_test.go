@@ -0,0 +1,47 @@
+package srco
+
+import "testing"
+
+func TestReplayPendingMessagesHasMore(t *testing.T) {
+	openTestDB(t)
+	previous := maxReplayMessages
+	maxReplayMessages = 2
+	t.Cleanup(func() { maxReplayMessages = previous })
+
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	var ids []int
+	for _, content := range []string{"one", "two", "three"} {
+		message := &ChatMessage{FromID: alice, ToID: bob, Content: content}
+		if err := storeChatMessage(message, 0); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, message.ID)
+	}
+
+	replay := func(lastSeenID int) ([]interface{}, interface{}) {
+		t.Helper()
+		c := connectTestClient(t, bob)
+		replayPendingMessages(c, lastSeenID)
+		for _, frame := range receivedFrames(c) {
+			if frame["type"] == "pending_messages" {
+				return frame["messages"].([]interface{}), frame["has_more"]
+			}
+		}
+		t.Fatal("no pending_messages frame")
+		return nil, nil
+	}
+
+	messages, hasMore := replay(0)
+	if len(messages) != 2 || hasMore != true {
+		t.Errorf("replay = %d messages, has_more %v; want 2, true", len(messages), hasMore)
+	}
+	if first := messages[0].(map[string]interface{}); first["id"] != float64(ids[0]) {
+		t.Errorf("replay starts at %v, want the oldest message %d", first["id"], ids[0])
+	}
+
+	messages, hasMore = replay(ids[1])
+	if len(messages) != 1 || hasMore != false {
+		t.Errorf("resumed replay = %d messages, has_more %v; want 1, false", len(messages), hasMore)
+	}
+}
@@ -0,0 +1,185 @@
+package srco
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How often the scheduler checks for scheduled posts that are due
+var postSchedulerInterval = time.Minute
+
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// resolvePostStatus validates the requested status and publish time of a post.
+// A publish time in the future turns a published post into a scheduled one.
+func resolvePostStatus(status, publishAt string) (string, sql.NullString, error) {
+	var at sql.NullString
+	if publishAt != "" {
+		t, err := time.Parse(time.RFC3339, publishAt)
+		if err != nil {
+			return "", at, errors.New("Invalid publish time")
+		}
+		at = sql.NullString{String: t.UTC().Format(sqliteTimeLayout), Valid: true}
+		if status != "draft" && t.After(time.Now()) {
+			return "scheduled", at, nil
+		}
+	}
+
+	switch status {
+	case "draft":
+		return "draft", at, nil
+	case "", "published":
+		return "published", sql.NullString{}, nil
+	default:
+		return "", at, errors.New("Invalid post status")
+	}
+}
+
+func getDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+        SELECT id, user_id, title, content, category, created_at, status,
+               COALESCE(publish_at, '') as publish_at
+        FROM posts
+        WHERE user_id = ? AND deleted_at IS NULL AND status IN ('draft', 'scheduled')
+        ORDER BY COALESCE(updated_at, created_at) DESC`, userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	drafts := []Post{}
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.Category,
+			&post.CreatedAt, &post.Status, &post.PublishAt); err != nil {
+			log.Printf("Error scanning draft: %v", err)
+			continue
+		}
+		drafts = append(drafts, post)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drafts)
+}
+
+func autosaveDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var draft struct {
+		ID       int    `json:"id"`
+		UserID   int    `json:"user_id"`
+		Title    string `json:"title"`
+		Content  string `json:"content"`
+		Category string `json:"category"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&draft); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE posts 
+		SET title = ?, content = ?, category = ?, updated_at = CURRENT_TIMESTAMP 
+		WHERE id = ? AND user_id = ? AND status = 'draft' AND deleted_at IS NULL`,
+		draft.Title, draft.Content, draft.Category, draft.ID, draft.UserID)
+	if err != nil {
+		http.Error(w, "Error saving draft", http.StatusInternalServerError)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Draft not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"saved_at": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// publishPostHandler publishes a draft immediately, or schedules it when publish_at is in the future
+func publishPostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		ID        int    `json:"id"`
+		UserID    int    `json:"user_id"`
+		PublishAt string `json:"publish_at"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	status, publishAt, err := resolvePostStatus("published", request.PublishAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result sql.Result
+	if status == "scheduled" {
+		result, err = db.Exec(`
+			UPDATE posts 
+			SET status = 'scheduled', publish_at = ? 
+			WHERE id = ? AND user_id = ? AND status IN ('draft', 'scheduled') AND deleted_at IS NULL`,
+			publishAt, request.ID, request.UserID)
+	} else {
+		result, err = db.Exec(`
+			UPDATE posts 
+			SET status = 'published', publish_at = NULL, created_at = CURRENT_TIMESTAMP 
+			WHERE id = ? AND user_id = ? AND status IN ('draft', 'scheduled') AND deleted_at IS NULL`,
+			request.ID, request.UserID)
+	}
+	if err != nil {
+		http.Error(w, "Error publishing post", http.StatusInternalServerError)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Draft not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// startPostScheduler publishes scheduled posts in the background once they are due
+func startPostScheduler() {
+	runEvery(postSchedulerInterval, func() {
+		if err := publishScheduledPosts(); err != nil {
+			log.Printf("Error publishing scheduled posts: %v", err)
+		}
+	})
+}
+
+func publishScheduledPosts() error {
+	// created_at becomes the publish time so the post is ordered by when it went live
+	_, err := db.Exec(`
+		UPDATE posts 
+		SET status = 'published', created_at = publish_at, publish_at = NULL 
+		WHERE status = 'scheduled' AND publish_at <= CURRENT_TIMESTAMP AND deleted_at IS NULL`)
+	return err
+}
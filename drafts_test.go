@@ -0,0 +1,135 @@
+package srco
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// createTestPost creates a post through createPostHandler and returns its ID and status
+func createTestPost(t *testing.T, author int, title, status, publishAt string) (int, string) {
+	t.Helper()
+
+	w := postJSON(createPostHandler, "/posts/create", Post{
+		UserID: author, Title: title, Content: "body", Category: "general",
+		Status: status, PublishAt: publishAt,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create %q = %d %s", title, w.Code, w.Body)
+	}
+	var created struct {
+		ID     int    `json:"id"`
+		Status string `json:"status"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	return created.ID, created.Status
+}
+
+func feedTitles(t *testing.T) []string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	getPostsHandler(w, httptest.NewRequest(http.MethodGet, "/posts", nil))
+	var posts []PostWithAuthor
+	json.NewDecoder(w.Body).Decode(&posts)
+	var titles []string
+	for _, p := range posts {
+		titles = append(titles, p.Title)
+	}
+	return titles
+}
+
+func TestDraftsAndScheduledPostsStayOutOfFeed(t *testing.T) {
+	openTestDB(t)
+	author := createTestUser(t, "alice")
+
+	createTestPost(t, author, "draft", "draft", "")
+	scheduledID, status := createTestPost(t, author, "scheduled", "", time.Now().Add(time.Hour).Format(time.RFC3339))
+	if status != "scheduled" {
+		t.Fatalf("post with a future publish time is %q, want scheduled", status)
+	}
+	createTestPost(t, author, "published", "", "")
+
+	if got := feedTitles(t); len(got) != 1 || got[0] != "published" {
+		t.Fatalf("feed = %v, want only the published post", got)
+	}
+
+	// Not due yet
+	if err := publishScheduledPosts(); err != nil {
+		t.Fatal(err)
+	}
+	if got := feedTitles(t); len(got) != 1 {
+		t.Fatalf("feed after an early scheduler run = %v, want only the published post", got)
+	}
+
+	if _, err := db.Exec("UPDATE posts SET publish_at = datetime('now', '-1 minute') WHERE id = ?", scheduledID); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishScheduledPosts(); err != nil {
+		t.Fatal(err)
+	}
+	var publishAt interface{}
+	if err := db.QueryRow("SELECT status, publish_at FROM posts WHERE id = ?", scheduledID).Scan(&status, &publishAt); err != nil {
+		t.Fatal(err)
+	}
+	if status != "published" || publishAt != nil {
+		t.Errorf("due post is %q with publish_at %v, want published and cleared", status, publishAt)
+	}
+	if got := feedTitles(t); len(got) != 2 {
+		t.Errorf("feed after the post was due = %v, want both published posts", got)
+	}
+}
+
+func TestAutosaveOnlyUpdatesDrafts(t *testing.T) {
+	openTestDB(t)
+	author := createTestUser(t, "alice")
+	draftID, _ := createTestPost(t, author, "draft", "draft", "")
+	publishedID, _ := createTestPost(t, author, "published", "published", "")
+
+	save := func(id int) int {
+		return postJSON(autosaveDraftHandler, "/drafts/autosave", map[string]interface{}{
+			"id": id, "user_id": author, "title": "edited", "content": "edited", "category": "general",
+		}).Code
+	}
+
+	if code := save(draftID); code != http.StatusOK {
+		t.Errorf("autosave of a draft = %d, want 200", code)
+	}
+	if code := save(publishedID); code != http.StatusNotFound {
+		t.Errorf("autosave of a published post = %d, want 404", code)
+	}
+
+	var title string
+	if err := db.QueryRow("SELECT title FROM posts WHERE id = ?", publishedID).Scan(&title); err != nil {
+		t.Fatal(err)
+	}
+	if title != "published" {
+		t.Errorf("autosave changed a published post's title to %q", title)
+	}
+}
+
+func TestResolvePostStatus(t *testing.T) {
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		status, publishAt, want string
+		wantErr                 bool
+	}{
+		{"", "", "published", false},
+		{"published", past, "published", false},
+		{"published", future, "scheduled", false},
+		{"draft", future, "draft", false},
+		{"archived", "", "", true},
+		{"", "tomorrow", "", true},
+	}
+	for _, tt := range tests {
+		got, _, err := resolvePostStatus(tt.status, tt.publishAt)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("resolvePostStatus(%q, %q) = %q, %v; want %q, error %v",
+				tt.status, tt.publishAt, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package srco
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// How long after sending a chat message its sender can still edit or delete it
+var chatEditWindow = 15 * time.Minute
+
+func handleEditMessage(c *client, env *envelope) error {
+	var content editMessageContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+	if strings.TrimSpace(content.Content) == "" {
+		return errInvalidContent
+	}
+	if err := checkMessageLength(content.Content); err != nil {
+		return err
+	}
+
+	toID, roomID, err := editableMessage(content.MessageID, c.userID)
+	if err != nil {
+		return err
+	}
+	if roomID == 0 && isBlockedBetween(c.userID, toID) {
+		return errBlocked
+	}
+
+	editedAt := time.Now().UTC().Format(sqliteTimeLayout)
+	_, err = db.Exec("UPDATE chat_messages SET content = ?, edited_at = ? WHERE id = ?",
+		content.Content, editedAt, content.MessageID)
+	if err != nil {
+		log.Printf("Error editing chat message: %v", err)
+		return errInternal
+	}
+
+	notifyMessageParticipants(c, toID, roomID, map[string]interface{}{
+		"type":       "message_edited",
+		"message_id": content.MessageID,
+		"room_id":    roomID,
+		"content":    content.Content,
+		"edited_at":  editedAt,
+	})
+	return nil
+}
+
+func handleDeleteMessage(c *client, env *envelope) error {
+	var content deleteMessageContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+
+	toID, roomID, err := editableMessage(content.MessageID, c.userID)
+	if err != nil {
+		return err
+	}
+
+	// The content and any attachment are dropped; history keeps a tombstone in its place
+	if err := deleteChatMessage(content.MessageID); err != nil {
+		log.Printf("Error deleting chat message: %v", err)
+		return errInternal
+	}
+
+	notifyMessageParticipants(c, toID, roomID, map[string]interface{}{
+		"type":       "message_deleted",
+		"message_id": content.MessageID,
+		"room_id":    roomID,
+	})
+	return nil
+}
+
+// deleteChatMessage blanks the message and removes its attachment, if any
+func deleteChatMessage(messageID int) error {
+	_, err := deleteWithAttachments("message_id = ?", []interface{}{messageID},
+		"UPDATE chat_messages SET content = '', deleted_at = CURRENT_TIMESTAMP WHERE id = ?")
+	return err
+}
+
+// editableMessage checks that the message was sent by userID, is not deleted and
+// is still within chatEditWindow. It returns the recipient or room of the message.
+func editableMessage(messageID, userID int) (toID, roomID int, err error) {
+	var to, room sql.NullInt64
+	err = db.QueryRow(`
+		SELECT to_id, room_id FROM chat_messages
+		WHERE id = ? AND from_id = ? AND deleted_at IS NULL
+		AND created_at >= datetime('now', ?)`,
+		messageID, userID, sqliteAgo(chatEditWindow)).Scan(&to, &room)
+	if err == sql.ErrNoRows {
+		return 0, 0, errMessageNotEditable
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(to.Int64), int(room.Int64), nil
+}
+
+// notifyMessageParticipants pushes a change to a message to everyone who can see
+// it, including the sender's other devices
+func notifyMessageParticipants(c *client, toID, roomID int, event map[string]interface{}) {
+	if roomID != 0 {
+		c.hub.sendToRoomFrom(roomID, c, event)
+		return
+	}
+	c.hub.sendToUser(toID, event)
+	if toID != c.userID {
+		c.hub.sendToUserExcept(c.userID, c, event)
+	}
+}
@@ -0,0 +1,61 @@
+package srco
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDeletedMessageAttachmentIsRemoved(t *testing.T) {
+	openTestDB(t)
+	previous := blobStore
+	blobStore = NewMemoryBlobStore()
+	t.Cleanup(func() { blobStore = previous })
+
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	blobStore.Put("file", strings.NewReader("secret"))
+	blobStore.Put("thumb", strings.NewReader("png"))
+	result, err := db.Exec(`
+		INSERT INTO attachments (user_id, filename, content_type, size, blob_key, thumbnail_key)
+		VALUES (?, 'photo.png', 'image/png', 6, 'file', 'thumb')`, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentID, _ := result.LastInsertId()
+
+	message := ChatMessage{FromID: alice, ToID: bob, Content: "look"}
+	if err := storeChatMessage(&message, int(attachmentID)); err != nil {
+		t.Fatal(err)
+	}
+	messageID := message.ID
+
+	download := func(query string) int {
+		w := httptest.NewRecorder()
+		url := "/chat/attachment?id=" + strconv.Itoa(int(attachmentID)) + "&user_id=" + strconv.Itoa(bob) + query
+		downloadChatAttachmentHandler(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w.Code
+	}
+	if code := download(""); code != http.StatusOK {
+		t.Fatalf("download before delete = %d, want 200", code)
+	}
+
+	if err := deleteChatMessage(messageID); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := download(""); code != http.StatusNotFound {
+		t.Errorf("download after delete = %d, want 404", code)
+	}
+	if code := download("&thumb=1"); code != http.StatusNotFound {
+		t.Errorf("thumbnail after delete = %d, want 404", code)
+	}
+	for _, key := range []string{"file", "thumb"} {
+		if _, err := blobStore.Get(key); err != ErrBlobNotFound {
+			t.Errorf("blob %q still stored after delete (err %v)", key, err)
+		}
+	}
+}
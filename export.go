@@ -0,0 +1,174 @@
+package srco
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var transcriptTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; }
+.message { margin: 0.5em 0; }
+.meta { color: #666; font-size: 0.85em; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Messages}}<div class="message">
+<div class="meta"><strong>{{.FromNick}}</strong> {{.Timestamp}}{{if .EditedAt}} (edited){{end}}</div>
+<div class="content">{{.Content}}</div>
+{{with .Attachment}}<div class="meta">Attachment: <a href="{{.URL}}">{{.Filename}}</a></div>{{end}}
+</div>
+{{end}}</body>
+</html>
+`))
+
+// exportConversationHandler returns a participant's whole private chat or room
+// history, oldest first, as JSON, plain text or an HTML transcript
+func exportConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	partnerID, _ := strconv.Atoi(r.URL.Query().Get("partner_id"))
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("room_id"))
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "text" && format != "html" {
+		http.Error(w, "Format must be json, text or html", http.StatusBadRequest)
+		return
+	}
+
+	var title, name, filter string
+	var args []interface{}
+	if roomID > 0 {
+		if _, ok := roomRole(roomID, userID); !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		room, err := getRoom(roomID)
+		if err != nil {
+			log.Printf("Error fetching room: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		title = "Room " + room.Name
+		name = fmt.Sprintf("room-%d", roomID)
+		filter = "cm.room_id = ?"
+		args = []interface{}{roomID}
+	} else if partnerID > 0 {
+		var partnerNick string
+		if err := db.QueryRow("SELECT nickname FROM users WHERE id = ?", partnerID).Scan(&partnerNick); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		title = "Conversation with " + partnerNick
+		name = fmt.Sprintf("conversation-%d", partnerID)
+		filter = "cm.room_id IS NULL AND ((cm.from_id = ? AND cm.to_id = ?) OR (cm.from_id = ? AND cm.to_id = ?))"
+		args = []interface{}{userID, partnerID, partnerID, userID}
+	} else {
+		http.Error(w, "Partner or room is required", http.StatusBadRequest)
+		return
+	}
+
+	messages, err := fetchTranscript(filter, args)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		setExportFilename(w, name+".json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"title":    title,
+			"messages": messages,
+		})
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		setExportFilename(w, name+".txt")
+		fmt.Fprintf(w, "%s\n\n", title)
+		for _, msg := range messages {
+			edited := ""
+			if msg.EditedAt != "" {
+				edited = " (edited)"
+			}
+			// Indent continuation lines so multi-line messages stay readable
+			content := strings.ReplaceAll(msg.Content, "\n", "\n    ")
+			fmt.Fprintf(w, "[%s] %s%s: %s\n", msg.Timestamp, msg.FromNick, edited, content)
+			if msg.Attachment != nil {
+				fmt.Fprintf(w, "    Attachment: %s (%s)\n", msg.Attachment.Filename, msg.Attachment.URL)
+			}
+		}
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		setExportFilename(w, name+".html")
+		err := transcriptTemplate.Execute(w, map[string]interface{}{
+			"Title":    title,
+			"Messages": messages,
+		})
+		if err != nil {
+			log.Printf("Error rendering transcript: %v", err)
+		}
+	}
+}
+
+func setExportFilename(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+// fetchTranscript returns every message matching filter oldest first, leaving out deleted ones
+func fetchTranscript(filter string, args []interface{}) ([]ChatMessage, error) {
+	rows, err := db.Query(`
+		SELECT cm.id, cm.from_id, COALESCE(cm.to_id, 0), COALESCE(cm.room_id, 0), cm.content, cm.created_at,
+		       u.nickname as from_nick, COALESCE(cm.edited_at, '') as edited_at
+		FROM chat_messages cm
+		JOIN users u ON cm.from_id = u.id
+		WHERE (`+filter+`) AND cm.deleted_at IS NULL
+		ORDER BY cm.id ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.FromID, &msg.ToID, &msg.RoomID, &msg.Content, &msg.Timestamp,
+			&msg.FromNick, &msg.EditedAt); err != nil {
+			// A transcript missing messages would look complete, so fail instead
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Batched to stay under SQLite's limit on query parameters
+	for i := 0; i < len(messages); i += 500 {
+		end := i + 500
+		if end > len(messages) {
+			end = len(messages)
+		}
+		loadChatAttachments(messages[i:end])
+	}
+	return messages, nil
+}
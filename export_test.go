@@ -0,0 +1,87 @@
+package srco
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func exportConversation(query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	exportConversationHandler(w, httptest.NewRequest(http.MethodGet, "/chat/export?"+query, nil))
+	return w
+}
+
+func TestExportConversation(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+
+	for _, message := range []*ChatMessage{
+		{FromID: alice, ToID: bob, Content: "<script>alert(1)</script>"},
+		{FromID: bob, ToID: alice, Content: "two\nlines"},
+		{FromID: alice, ToID: carol, Content: "not in this conversation"},
+	} {
+		if err := storeChatMessage(message, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conversation := "user_id=" + strconv.Itoa(alice) + "&partner_id=" + strconv.Itoa(bob)
+
+	w := exportConversation(conversation + "&format=html")
+	if w.Code != http.StatusOK {
+		t.Fatalf("html export = %d %s", w.Code, w.Body)
+	}
+	if body := w.Body.String(); strings.Contains(body, "<script>") || !strings.Contains(body, "&lt;script&gt;") {
+		t.Errorf("html transcript does not escape message content:\n%s", body)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "conversation-"+strconv.Itoa(bob)+".html") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	w = exportConversation(conversation + "&format=json")
+	var export struct {
+		Messages []ChatMessage `json:"messages"`
+	}
+	json.NewDecoder(w.Body).Decode(&export)
+	if len(export.Messages) != 2 || export.Messages[0].FromID != alice || export.Messages[1].FromID != bob {
+		t.Errorf("json export = %+v, want the two messages between alice and bob, oldest first", export.Messages)
+	}
+
+	w = exportConversation(conversation + "&format=text")
+	if body := w.Body.String(); !strings.Contains(body, "two\n    lines") {
+		t.Errorf("text transcript does not indent continuation lines:\n%s", body)
+	}
+
+	if w := exportConversation(conversation + "&format=pdf"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown format = %d, want 400", w.Code)
+	}
+}
+
+func TestExportRoomRequiresMembership(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	w := postJSON(createRoomHandler, "/rooms", map[string]interface{}{"user_id": alice, "name": "room"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create room = %d %s", w.Code, w.Body)
+	}
+	var room Room
+	json.NewDecoder(w.Body).Decode(&room)
+	if err := storeChatMessage(&ChatMessage{FromID: alice, RoomID: room.ID, Content: "hello room"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	query := "room_id=" + strconv.Itoa(room.ID) + "&format=text&user_id="
+	if w := exportConversation(query + strconv.Itoa(bob)); w.Code != http.StatusUnauthorized {
+		t.Errorf("room export by a non-member = %d, want 401", w.Code)
+	}
+	if w := exportConversation(query + strconv.Itoa(alice)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hello room") {
+		t.Errorf("room export by a member = %d %q", w.Code, w.Body)
+	}
+}
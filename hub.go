@@ -0,0 +1,155 @@
+package srco
+
+import (
+	"sync"
+	"time"
+)
+
+// hub holds the realtime state of one server instance: its connections, what
+// other instances reported through the broker, and pending presence broadcasts.
+// A process normally runs defaultHub; tests create several to act as separate nodes.
+type hub struct {
+	// Identifies this instance in broker events
+	nodeID string
+
+	// How events reach other instances
+	broker Broker
+
+	// Connections on this instance, one per tab or device
+	online struct {
+		sync.RWMutex
+		users map[int]map[*client]bool
+	}
+
+	// Connected users reported by other instances
+	remote struct {
+		sync.RWMutex
+		nodes map[string]*remoteNode
+	}
+
+	// Presence changes waiting to be broadcast. Changes are collected for
+	// presenceBatchDelay and then sent to every connection as one frame, so a
+	// burst of connects and disconnects costs one write per connection.
+	updates struct {
+		sync.Mutex
+		pending map[int]bool
+		timer   *time.Timer
+		stopped bool
+	}
+
+	// Serializes flushes and guards lastBroadcast and lastPublished
+	flushMu sync.Mutex
+
+	// The presence last broadcast for each user, to drop changes that cancel out
+	lastBroadcast map[int]string
+
+	// The local connection state last published to other instances
+	lastPublished map[int]string
+}
+
+// The hub of this instance; replace it before startBroker to use another broker
+var defaultHub = newHub(NewMemoryBroker())
+
+func newHub(broker Broker) *hub {
+	h := &hub{
+		nodeID:        newNodeID(),
+		broker:        broker,
+		lastBroadcast: make(map[int]string),
+		lastPublished: make(map[int]string),
+	}
+	h.online.users = make(map[int]map[*client]bool)
+	h.remote.nodes = make(map[string]*remoteNode)
+	h.updates.pending = make(map[int]bool)
+	return h
+}
+
+// connect registers c and sends it the user list. The user stays online until
+// their last connection closes. The new connection gets a full snapshot,
+// everyone else only a delta.
+func (h *hub) connect(c *client) {
+	if h.registerClient(c) {
+		h.notifyPresenceChanged(c.userID)
+	}
+	sendUserList(c)
+}
+
+// disconnect closes c and, if it was the user's last connection, marks them offline
+func (h *hub) disconnect(c *client) {
+	c.close()
+	if h.unregisterClient(c) {
+		recordLastSeen(c.userID)
+		h.notifyPresenceChanged(c.userID)
+	}
+}
+
+// registerClient adds c to its user's connections and reports whether it is
+// the user's first, i.e. whether the user just came online
+func (h *hub) registerClient(c *client) bool {
+	h.online.Lock()
+	defer h.online.Unlock()
+
+	conns, ok := h.online.users[c.userID]
+	if !ok {
+		conns = make(map[*client]bool)
+		h.online.users[c.userID] = conns
+	}
+	conns[c] = true
+	return len(conns) == 1
+}
+
+// unregisterClient removes c and reports whether it was the user's last
+// connection, i.e. whether the user just went offline
+func (h *hub) unregisterClient(c *client) bool {
+	h.online.Lock()
+	defer h.online.Unlock()
+
+	conns, ok := h.online.users[c.userID]
+	if !ok || !conns[c] {
+		return false
+	}
+	delete(conns, c)
+	if len(conns) > 0 {
+		return false
+	}
+	delete(h.online.users, c.userID)
+	return true
+}
+
+// sendToUser queues msg for every connection of the user, on this and other instances
+func (h *hub) sendToUser(userID int, msg interface{}) {
+	h.sendToUserExcept(userID, nil, msg)
+}
+
+// sendToUserExcept queues msg for every connection of the user other than skip,
+// e.g. to sync a message sent from one device to the user's other devices
+func (h *hub) sendToUserExcept(userID int, skip *client, msg interface{}) {
+	h.deliverToUser(userID, skip, msg)
+	h.publishToUser(userID, msg)
+}
+
+// deliverToUser queues msg for the user's connections on this instance only
+func (h *hub) deliverToUser(userID int, skip *client, msg interface{}) {
+	h.online.RLock()
+	defer h.online.RUnlock()
+
+	for c := range h.online.users[userID] {
+		if c != skip {
+			c.enqueue(msg)
+		}
+	}
+}
+
+// stop cancels pending presence broadcasts and waits for one in progress;
+// the hub broadcasts no presence changes afterwards
+func (h *hub) stop() {
+	h.updates.Lock()
+	h.updates.stopped = true
+	if h.updates.timer != nil {
+		h.updates.timer.Stop()
+		h.updates.timer = nil
+	}
+	h.updates.Unlock()
+
+	h.flushMu.Lock()
+	h.flushMu.Unlock()
+}
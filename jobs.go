@@ -0,0 +1,14 @@
+package srco
+
+import "time"
+
+// runEvery calls job in the background every interval, for the life of the process
+func runEvery(interval time.Duration, job func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			job()
+		}
+	}()
+}
@@ -0,0 +1,155 @@
+package srco
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	// Markdown renderer; raw HTML in the source is escaped and shown as text
+	markdown = goldmark.New(
+		goldmark.WithExtensions(extension.Strikethrough, extension.Linkify),
+		goldmark.WithRendererOptions(renderer.WithNodeRenderers(util.Prioritized(escapedHTMLRenderer{}, 100))),
+	)
+
+	// Allowlist of the elements the renderer is expected to produce
+	markdownPolicy = newMarkdownPolicy()
+
+	// Rendered HTML keyed by a hash of the source, so each revision is rendered once
+	renderCache = newRenderCache(1000)
+)
+
+func newMarkdownPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "strong", "em", "del", "code", "pre", "blockquote",
+		"ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+	p.AllowAttrs("start").Matching(regexp.MustCompile(`^\d+$`)).OnElements("ol")
+	return p
+}
+
+// escapedHTMLRenderer writes raw HTML from the source as escaped text. goldmark's
+// default renderer replaces it with a comment, which the sanitizer then drops,
+// so "use <br> here" would silently lose its tag.
+type escapedHTMLRenderer struct{}
+
+func (r escapedHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindRawHTML, r.renderRawHTML)
+	reg.Register(ast.KindHTMLBlock, r.renderHTMLBlock)
+}
+
+func (r escapedHTMLRenderer) renderRawHTML(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*ast.RawHTML)
+		for i := 0; i < n.Segments.Len(); i++ {
+			segment := n.Segments.At(i)
+			w.Write(util.EscapeHTML(segment.Value(source)))
+		}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// renderHTMLBlock shows an HTML block as a paragraph of its source
+func (r escapedHTMLRenderer) renderHTMLBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.HTMLBlock)
+	if entering {
+		w.WriteString("<p>")
+		for i := 0; i < n.Lines().Len(); i++ {
+			line := n.Lines().At(i)
+			w.Write(util.EscapeHTML(line.Value(source)))
+		}
+	} else {
+		if n.HasClosure() {
+			w.Write(util.EscapeHTML(n.ClosureLine.Value(source)))
+		}
+		w.WriteString("</p>\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+// renderMarkdown converts post or comment content into sanitized HTML
+func renderMarkdown(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	key := hex.EncodeToString(sum[:])
+
+	if html, ok := renderCache.get(key); ok {
+		return html
+	}
+
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(content), &buf); err != nil {
+		log.Printf("Markdown rendering error: %v", err)
+		return ""
+	}
+
+	html := markdownPolicy.Sanitize(buf.String())
+	renderCache.put(key, html)
+	return html
+}
+
+// lruCache is a small LRU cache of rendered HTML
+type lruCache struct {
+	sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value string
+}
+
+func newRenderCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*lruEntry).value, true
+	}
+	return "", false
+}
+
+func (c *lruCache) put(key, value string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		el.Value.(*lruEntry).value = value
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
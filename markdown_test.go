@@ -0,0 +1,84 @@
+package srco
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "javascript link",
+			input:    "[click](javascript:alert(1))",
+			excludes: []string{"javascript:", "<a "},
+		},
+		{
+			name:     "autolinked url",
+			input:    "see https://example.com",
+			contains: []string{`href="https://example.com"`, `rel="nofollow noopener"`},
+		},
+		{
+			name:     "script block",
+			input:    "<script>alert(1)</script>",
+			contains: []string{"&lt;script&gt;alert(1)&lt;/script&gt;"},
+			excludes: []string{"<script"},
+		},
+		{
+			name:     "inline html is shown as text",
+			input:    "use <br> here",
+			contains: []string{"use &lt;br&gt; here"},
+		},
+		{
+			name:     "event handler attribute",
+			input:    "<img src=x onerror=alert(1)>",
+			contains: []string{"&lt;img src=x onerror=alert(1)&gt;"},
+			excludes: []string{"<img"},
+		},
+		{
+			name:     "fenced code keeps language class",
+			input:    "```go\nfmt.Println(1)\n```",
+			contains: []string{`<code class="language-go">`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html := renderMarkdown(tt.input)
+			for _, want := range tt.contains {
+				if !strings.Contains(html, want) {
+					t.Errorf("renderMarkdown(%q) = %q, want it to contain %q", tt.input, html, want)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(html, unwanted) {
+					t.Errorf("renderMarkdown(%q) = %q, must not contain %q", tt.input, html, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownPolicy(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`<a href="javascript:alert(1)">x</a>`, "x"},
+		{`<script>alert(1)</script>`, ""},
+		{`<code class="language-c++">x</code>`, `<code class="language-c++">x</code>`},
+		{`<code class="hidden">x</code>`, "<code>x</code>"},
+		{`<pre class="language-go">x</pre>`, "<pre>x</pre>"},
+		{`<ol start="3" onclick="x()"><li>a</li></ol>`, `<ol start="3"><li>a</li></ol>`},
+	}
+
+	for _, tt := range tests {
+		if got := markdownPolicy.Sanitize(tt.input); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
@@ -19,18 +19,31 @@ func createPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.Exec("INSERT INTO posts (user_id, title, content, category) VALUES (?, ?, ?, ?)",
-		post.UserID, post.Title, post.Content, post.Category)
+	status, publishAt, err := resolvePostStatus(post.Status, post.PublishAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content, category, status, publish_at) VALUES (?, ?, ?, ?, ?, ?)",
+		post.UserID, post.Title, post.Content, post.Category, status, publishAt)
 	if err != nil {
 		http.Error(w, "Error creating post", http.StatusInternalServerError)
 		return
 	}
 
+	id, _ := result.LastInsertId()
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     id,
+		"status": status,
+	})
 }
 
 func getPostsHandler(w http.ResponseWriter, r *http.Request) {
 	category := r.URL.Query().Get("category")
+	viewerID := r.URL.Query().Get("user_id")
 
 	query := `
         SELECT 
@@ -53,19 +66,25 @@ func getPostsHandler(w http.ResponseWriter, r *http.Request) {
             FROM likes_dislikes
             GROUP BY post_id
         ) l ON p.id = l.post_id
+        WHERE p.deleted_at IS NULL AND p.status = 'published'
     `
 
-	var rows *sql.Rows
-	var err error
+	var args []interface{}
 
 	if category != "all" && category != "" {
-		query += " WHERE p.category = ? ORDER BY p.created_at DESC"
-		rows, err = db.Query(query, category)
-	} else {
-		query += " ORDER BY p.created_at DESC"
-		rows, err = db.Query(query)
+		query += " AND p.category = ?"
+		args = append(args, category)
+	}
+
+	// Hide posts by authors the viewer has muted
+	if viewerID != "" {
+		query += " AND p.user_id NOT IN (SELECT muted_id FROM user_mutes WHERE user_id = ?)"
+		args = append(args, viewerID)
 	}
 
+	query += " ORDER BY p.created_at DESC"
+	rows, err := db.Query(query, args...)
+
 	if err != nil {
 		log.Printf("Database error: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -90,6 +109,7 @@ func getPostsHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Scan error: %v", err)
 			continue
 		}
+		post.ContentHTML = renderMarkdown(post.Content)
 		posts = append(posts, post)
 	}
 
@@ -123,7 +143,8 @@ func getPostHandler(w http.ResponseWriter, r *http.Request) {
             p.created_at,
             COALESCE(l.likes, 0) as likes,
             COALESCE(l.dislikes, 0) as dislikes,
-            u.nickname as author_nickname
+            u.nickname as author_nickname,
+            p.status
         FROM posts p
         LEFT JOIN users u ON p.user_id = u.id
         LEFT JOIN (
@@ -134,7 +155,8 @@ func getPostHandler(w http.ResponseWriter, r *http.Request) {
             FROM likes_dislikes
             GROUP BY post_id
         ) l ON p.id = l.post_id
-        WHERE p.id = ?`, postID).Scan(
+        WHERE p.id = ? AND p.deleted_at IS NULL
+        AND (p.status = 'published' OR p.user_id = ?)`, postID, userID).Scan(
 		&post.ID,
 		&post.UserID,
 		&post.Title,
@@ -143,13 +165,15 @@ func getPostHandler(w http.ResponseWriter, r *http.Request) {
 		&post.CreatedAt,
 		&post.Likes,
 		&post.Dislikes,
-		&post.AuthorNickname)
+		&post.AuthorNickname,
+		&post.Status)
 
 	if err != nil {
 		log.Printf("Database error: %v", err)
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	post.ContentHTML = renderMarkdown(post.Content)
 
 	// Fetch user's reaction if logged in
 	if userID != "" {
@@ -172,8 +196,9 @@ func getPostHandler(w http.ResponseWriter, r *http.Request) {
         SELECT c.id, c.content, c.created_at, u.nickname
         FROM comments c
         JOIN users u ON c.user_id = u.id
-        WHERE c.post_id = ?
-        ORDER BY c.created_at DESC`, postID)
+        WHERE c.post_id = ? AND c.deleted_at IS NULL
+        AND c.user_id NOT IN (SELECT muted_id FROM user_mutes WHERE user_id = ?)
+        ORDER BY c.created_at DESC`, postID, userID)
 	if err != nil {
 		log.Printf("Error fetching comments: %v", err)
 	} else {
@@ -184,10 +209,13 @@ func getPostHandler(w http.ResponseWriter, r *http.Request) {
 				log.Printf("Error scanning comment: %v", err)
 				continue
 			}
+			comment.ContentHTML = renderMarkdown(comment.Content)
 			post.Comments = append(post.Comments, comment)
 		}
 	}
 
+	post.Attachments = getPostAttachments(post.ID)
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(post); err != nil {
 		log.Printf("JSON encoding error: %v", err)
@@ -213,6 +241,11 @@ func likeDislikeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !postExists(reaction.PostID) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
 	// Check if the user has already reacted
 	var existingReactionID int
 	err := db.QueryRow(`
@@ -266,6 +299,11 @@ func addCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !postExists(comment.PostID) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
 	_, err := db.Exec(`
         INSERT INTO comments (post_id, user_id, content) 
         VALUES (?, ?, ?)`,
@@ -294,8 +332,25 @@ func deletePostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.Exec("DELETE FROM posts WHERE id = ? AND user_id = ?",
-		request.PostID, request.UserID)
+	// Verify the user owns this post or is a moderator
+	var postUserID int
+	err := db.QueryRow("SELECT user_id FROM posts WHERE id = ? AND deleted_at IS NULL", request.PostID).Scan(&postUserID)
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	if postUserID != request.UserID && !isModerator(request.UserID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Soft delete; the post stays in the trash until purgeTrash removes it
+	_, err = db.Exec(`
+		UPDATE posts 
+		SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? 
+		WHERE id = ?`,
+		request.UserID, request.PostID)
 	if err != nil {
 		http.Error(w, "Error deleting post", http.StatusInternalServerError)
 		return
@@ -325,7 +380,7 @@ func updatePostHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Verify that the user owns this post
 	var postUserID int
-	err := db.QueryRow("SELECT user_id FROM posts WHERE id = ?", post.ID).Scan(&postUserID)
+	err := db.QueryRow("SELECT user_id FROM posts WHERE id = ? AND deleted_at IS NULL", post.ID).Scan(&postUserID)
 	if err != nil {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
@@ -366,20 +421,24 @@ func deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify the user owns this comment
+	// Verify the user owns this comment or is a moderator
 	var commentUserID int
-	err := db.QueryRow("SELECT user_id FROM comments WHERE id = ?", request.CommentID).Scan(&commentUserID)
+	err := db.QueryRow("SELECT user_id FROM comments WHERE id = ? AND deleted_at IS NULL", request.CommentID).Scan(&commentUserID)
 	if err != nil {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	}
 
-	if commentUserID != request.UserID {
+	if commentUserID != request.UserID && !isModerator(request.UserID) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM comments WHERE id = ?", request.CommentID)
+	_, err = db.Exec(`
+		UPDATE comments 
+		SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? 
+		WHERE id = ?`,
+		request.UserID, request.CommentID)
 	if err != nil {
 		http.Error(w, "Error deleting comment", http.StatusInternalServerError)
 		return
@@ -407,7 +466,7 @@ func updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Verify the user owns this comment
 	var commentUserID int
-	err := db.QueryRow("SELECT user_id FROM comments WHERE id = ?", request.CommentID).Scan(&commentUserID)
+	err := db.QueryRow("SELECT user_id FROM comments WHERE id = ? AND deleted_at IS NULL", request.CommentID).Scan(&commentUserID)
 	if err != nil {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
@@ -426,3 +485,9 @@ func updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func postExists(postID int) bool {
+	var id int
+	err := db.QueryRow("SELECT id FROM posts WHERE id = ? AND deleted_at IS NULL AND status = 'published'", postID).Scan(&id)
+	return err == nil
+}
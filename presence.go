@@ -0,0 +1,380 @@
+package srco
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// A connected user with no activity on any connection for this long shows as idle
+	idleAfter = 5 * time.Minute
+
+	// How often connected users are checked for becoming idle
+	presenceCheckInterval = 30 * time.Second
+
+	// Longest custom status message, in characters
+	maxStatusMessageLength = 80
+
+	// Presence states a user can choose; "idle" and "offline" are derived
+	userPresenceStates = map[string]bool{"online": true, "dnd": true, "invisible": true}
+
+	// Who can see when a user was last online
+	lastSeenVisibilities = map[string]bool{"everyone": true, "contacts": true, "nobody": true}
+)
+
+// How long presence changes are collected before being broadcast
+var presenceBatchDelay = 250 * time.Millisecond
+
+// notifyPresenceChanged schedules a delta broadcast for the user
+func (h *hub) notifyPresenceChanged(userID int) {
+	h.updates.Lock()
+	defer h.updates.Unlock()
+
+	if h.updates.stopped {
+		return
+	}
+	h.updates.pending[userID] = true
+	if h.updates.timer == nil {
+		h.updates.timer = time.AfterFunc(presenceBatchDelay, h.flushPresenceUpdates)
+	}
+}
+
+// flushPresenceUpdates broadcasts a user_online or user_offline event for every
+// pending user whose presence actually changed. last_seen is only included for
+// users who share it with everyone; contacts see it in the user list snapshot.
+func (h *hub) flushPresenceUpdates() {
+	h.updates.Lock()
+	pending := h.updates.pending
+	h.updates.pending = make(map[int]bool)
+	h.updates.timer = nil
+	stopped := h.updates.stopped
+	h.updates.Unlock()
+
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+	if stopped {
+		return
+	}
+
+	ids := make([]interface{}, 0, len(pending))
+	placeholders := make([]string, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+		placeholders = append(placeholders, "?")
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, nickname, COALESCE(presence, 'online'), COALESCE(status_message, ''),
+		       COALESCE(last_seen, ''), COALESCE(last_seen_visibility, 'everyone')
+		FROM users 
+		WHERE id IN (`+strings.Join(placeholders, ",")+`)`, ids...)
+	if err != nil {
+		log.Printf("Error fetching presence updates: %v", err)
+		return
+	}
+	var users []userListEntry
+	for rows.Next() {
+		var u userListEntry
+		if err := rows.Scan(&u.id, &u.nickname, &u.presence, &u.statusMessage,
+			&u.lastSeen, &u.lastSeenVisibility); err != nil {
+			continue
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+
+	// Tell other instances about changes to the connections held here
+	local := h.localPresence()
+	changes := make(map[int]string)
+	for id := range pending {
+		if local[id] != h.lastPublished[id] {
+			changes[id] = local[id]
+			if local[id] == "" {
+				delete(h.lastPublished, id)
+			} else {
+				h.lastPublished[id] = local[id]
+			}
+		}
+	}
+	if len(changes) > 0 {
+		h.publishPresence(presenceSync{States: changes})
+	}
+
+	connected := h.connectedPresence()
+	var events []map[string]interface{}
+	for _, u := range users {
+		presence := effectivePresence(u, connected)
+		statusMessage := visibleStatusMessage(u)
+		key := presence + "|" + statusMessage
+		if h.lastBroadcast[u.id] == key {
+			continue
+		}
+		h.lastBroadcast[u.id] = key
+
+		event := map[string]interface{}{
+			"type":     "user_online",
+			"id":       u.id,
+			"nickname": u.nickname,
+			"presence": presence,
+		}
+		if u.presence != "invisible" {
+			event["status_message"] = statusMessage
+		}
+		if presence == "offline" {
+			event["type"] = "user_offline"
+			if u.lastSeenVisibility == "everyone" && u.lastSeen != "" {
+				event["last_seen"] = u.lastSeen
+			}
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	message := map[string]interface{}{
+		"type":   "presence_updates",
+		"events": events,
+	}
+
+	// Every instance computes and sends its own presence events, so this is local only.
+	// enqueue never blocks, so holding the read lock while sending is cheap.
+	h.online.RLock()
+	defer h.online.RUnlock()
+	for _, conns := range h.online.users {
+		for c := range conns {
+			c.enqueue(message)
+		}
+	}
+}
+
+// markActive records activity on c and reports whether its user was idle until now
+func (h *hub) markActive(c *client) bool {
+	now := time.Now()
+	wasIdle := h.userIdle(c.userID, now)
+	atomic.StoreInt64(&c.lastActive, now.UnixNano())
+	return wasIdle
+}
+
+func (h *hub) userIdle(userID int, now time.Time) bool {
+	h.online.RLock()
+	defer h.online.RUnlock()
+
+	conns := h.online.users[userID]
+	if len(conns) == 0 {
+		return false
+	}
+	cutoff := now.Add(-idleAfter).UnixNano()
+	for c := range conns {
+		if atomic.LoadInt64(&c.lastActive) > cutoff {
+			return false
+		}
+	}
+	return true
+}
+
+// connectedPresence returns "online" or "idle" for every user with an open
+// connection on any instance
+func (h *hub) connectedPresence() map[int]string {
+	presence := h.localPresence()
+	h.mergeRemotePresence(presence)
+	return presence
+}
+
+// localPresence is connectedPresence for the connections on this instance
+func (h *hub) localPresence() map[int]string {
+	cutoff := time.Now().Add(-idleAfter).UnixNano()
+
+	h.online.RLock()
+	defer h.online.RUnlock()
+
+	presence := make(map[int]string, len(h.online.users))
+	for uid, conns := range h.online.users {
+		presence[uid] = "idle"
+		for c := range conns {
+			if atomic.LoadInt64(&c.lastActive) > cutoff {
+				presence[uid] = "online"
+				break
+			}
+		}
+	}
+	return presence
+}
+
+// effectivePresence combines the state a user chose with their connections:
+// invisible users and users without connections show as offline
+func effectivePresence(u userListEntry, connected map[int]string) string {
+	state, ok := connected[u.id]
+	if !ok || u.presence == "invisible" {
+		return "offline"
+	}
+	if u.presence == "dnd" {
+		return "dnd"
+	}
+	return state
+}
+
+// visibleStatusMessage is the status message others see; invisible users show none
+func visibleStatusMessage(u userListEntry) string {
+	if u.presence == "invisible" {
+		return ""
+	}
+	return u.statusMessage
+}
+
+func lastSeenVisibleTo(u userListEntry, viewerID int, contacts map[int]bool) bool {
+	switch u.lastSeenVisibility {
+	case "nobody":
+		return u.id == viewerID
+	case "contacts":
+		return u.id == viewerID || contacts[u.id]
+	default:
+		return true
+	}
+}
+
+// chatContacts returns the users the given user has exchanged private messages with
+func chatContacts(userID int) map[int]bool {
+	contacts := make(map[int]bool)
+	rows, err := db.Query(`
+		SELECT to_id FROM chat_messages WHERE from_id = ? AND to_id IS NOT NULL
+		UNION
+		SELECT from_id FROM chat_messages WHERE to_id = ?`, userID, userID)
+	if err != nil {
+		log.Printf("Error fetching chat contacts: %v", err)
+		return contacts
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			contacts[id] = true
+		}
+	}
+	return contacts
+}
+
+// recordLastSeen stores when the user's last connection closed, unless they are invisible
+func recordLastSeen(userID int) {
+	_, err := db.Exec(`
+		UPDATE users SET last_seen = CURRENT_TIMESTAMP 
+		WHERE id = ? AND COALESCE(presence, 'online') != 'invisible'`, userID)
+	if err != nil {
+		log.Printf("Error recording last seen: %v", err)
+	}
+}
+
+// updatePresence changes the user's chosen state and status message. Empty
+// presence or a nil status message leave the current value unchanged.
+func updatePresence(userID int, presence string, statusMessage *string) error {
+	if presence != "" && !userPresenceStates[presence] {
+		return errInvalidContent
+	}
+	if statusMessage != nil && len([]rune(*statusMessage)) > maxStatusMessageLength {
+		return errInvalidContent
+	}
+
+	if presence != "" {
+		if _, err := db.Exec("UPDATE users SET presence = ? WHERE id = ?", presence, userID); err != nil {
+			return err
+		}
+	}
+	if statusMessage != nil {
+		if _, err := db.Exec("UPDATE users SET status_message = ? WHERE id = ?", *statusMessage, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleSetPresence(c *client, env *envelope) error {
+	var content setPresenceContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+
+	if err := updatePresence(c.userID, content.Presence, content.StatusMessage); err != nil {
+		if _, ok := err.(*protocolError); ok {
+			return err
+		}
+		log.Printf("Error updating presence: %v", err)
+		return errInternal
+	}
+
+	c.hub.notifyPresenceChanged(c.userID)
+	c.hub.publishPresenceRefresh(c.userID)
+	return nil
+}
+
+func updatePresenceSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		UserID             int     `json:"user_id"`
+		Presence           string  `json:"presence"`
+		StatusMessage      *string `json:"status_message"`
+		LastSeenVisibility string  `json:"last_seen_visibility"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if request.LastSeenVisibility != "" && !lastSeenVisibilities[request.LastSeenVisibility] {
+		http.Error(w, "Invalid last seen visibility", http.StatusBadRequest)
+		return
+	}
+
+	if err := updatePresence(request.UserID, request.Presence, request.StatusMessage); err != nil {
+		if _, ok := err.(*protocolError); ok {
+			http.Error(w, "Invalid presence or status message", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Error updating presence", http.StatusInternalServerError)
+		return
+	}
+
+	if request.LastSeenVisibility != "" {
+		_, err := db.Exec("UPDATE users SET last_seen_visibility = ? WHERE id = ?",
+			request.LastSeenVisibility, request.UserID)
+		if err != nil {
+			http.Error(w, "Error updating presence", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	defaultHub.notifyPresenceChanged(request.UserID)
+	defaultHub.publishPresenceRefresh(request.UserID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// startPresenceMonitor periodically checks for users going idle and pushes the
+// change to everyone. Coming back from idle is handled as soon as a frame arrives.
+// Each check also sends other instances the full list of local connections.
+func (h *hub) startPresenceMonitor() {
+	previous := h.localPresence()
+	runEvery(presenceCheckInterval, func() {
+		current := h.localPresence()
+		for uid, state := range current {
+			if prev, ok := previous[uid]; ok && prev != state {
+				h.notifyPresenceChanged(uid)
+			}
+		}
+		previous = current
+
+		// A full sync doubles as a heartbeat telling other instances this one is alive
+		h.publishPresence(presenceSync{Full: true, States: current})
+	})
+}
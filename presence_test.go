@@ -0,0 +1,198 @@
+package srco
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// How many users change presence at once in BenchmarkPresenceBroadcast
+const presenceBurst = 5
+
+// BenchmarkPresenceBroadcast compares telling every connection about a burst
+// of users coming online with the full user list per change, as the server
+// used to, against one batched delta
+func BenchmarkPresenceBroadcast(b *testing.B) {
+	// Each full list holds an entry per user and is built for every connection,
+	// so much beyond a thousand connections it no longer fits in memory
+	for _, connections := range []int{500, 1000} {
+		b.Run("full_list/"+strconv.Itoa(connections), func(b *testing.B) {
+			benchmarkPresenceBurst(b, connections, func(h *hub, burst []*client) {
+				for _, c := range burst {
+					h.registerClient(c)
+					broadcastFullUserList(h)
+				}
+			})
+		})
+	}
+	for _, connections := range []int{500, 1000, 5000} {
+		b.Run("delta/"+strconv.Itoa(connections), func(b *testing.B) {
+			benchmarkPresenceBurst(b, connections, func(h *hub, burst []*client) {
+				for _, c := range burst {
+					h.registerClient(c)
+					h.notifyPresenceChanged(c.userID)
+				}
+				h.flushPresenceUpdates()
+			})
+		})
+	}
+}
+
+// benchmarkPresenceBurst connects one client per user, then measures
+// announcing presenceBurst more users coming online. It reports the frames
+// queued for all connections and the encoded bytes one connection receives.
+func benchmarkPresenceBurst(b *testing.B, connections int, announce func(h *hub, burst []*client)) {
+	openTestDB(b)
+	h := defaultHub
+
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < connections+presenceBurst; i++ {
+		_, err := tx.Exec("INSERT INTO users (nickname, email, password) VALUES (?, ?, '')",
+			"user"+strconv.Itoa(i), "user"+strconv.Itoa(i)+"@example.com")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+
+	clients := make([]*client, connections)
+	for i := range clients {
+		clients[i] = newClient(h, i+1, nil)
+		h.registerClient(clients[i])
+	}
+	burst := make([]*client, presenceBurst)
+	for i := range burst {
+		burst[i] = newClient(h, connections+i+1, nil)
+	}
+
+	var frames, bytes int
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		announce(h, burst)
+
+		b.StopTimer()
+		for _, msg := range drain(clients[0]) {
+			data, _ := json.Marshal(msg)
+			frames++
+			bytes += len(data)
+		}
+		for _, c := range append(clients[1:], burst...) {
+			frames += len(drain(c))
+		}
+		for _, c := range burst {
+			h.unregisterClient(c)
+			h.notifyPresenceChanged(c.userID)
+		}
+		h.flushPresenceUpdates()
+		for _, c := range clients {
+			drain(c)
+		}
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(frames)/float64(b.N), "frames/op")
+	b.ReportMetric(float64(bytes)/float64(b.N), "conn-bytes/op")
+}
+
+// broadcastFullUserList sends every connection the complete user list, which
+// is what the server did on each presence change before deltas
+func broadcastFullUserList(h *hub) {
+	users, err := fetchUserList()
+	if err != nil {
+		return
+	}
+	connected := h.connectedPresence()
+
+	h.online.RLock()
+	recipients := make(map[int][]*client, len(h.online.users))
+	for uid, conns := range h.online.users {
+		for c := range conns {
+			recipients[uid] = append(recipients[uid], c)
+		}
+	}
+	h.online.RUnlock()
+
+	for uid, clients := range recipients {
+		message := userListMessage(uid, users, connected)
+		for _, c := range clients {
+			c.enqueue(message)
+		}
+	}
+}
+
+// Invisible users show as offline, so their status message must not reveal them
+func TestInvisibleStatusMessageHidden(t *testing.T) {
+	openTestDB(t)
+	h := defaultHub
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	status := "back soon"
+	if err := updatePresence(alice, "", &status); err != nil {
+		t.Fatal(err)
+	}
+
+	connectTestClient(t, alice)
+	watcher := connectTestClient(t, bob)
+	h.flushPresenceUpdates()
+	drain(watcher)
+
+	aliceEvent := func() map[string]interface{} {
+		t.Helper()
+		h.notifyPresenceChanged(alice)
+		h.flushPresenceUpdates()
+		for _, frame := range receivedFrames(watcher) {
+			events, _ := frame["events"].([]interface{})
+			for _, e := range events {
+				if event := e.(map[string]interface{}); event["id"] == float64(alice) {
+					return event
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := updatePresence(alice, "invisible", nil); err != nil {
+		t.Fatal(err)
+	}
+	event := aliceEvent()
+	if event == nil || event["type"] != "user_offline" {
+		t.Fatalf("going invisible sent %v, want user_offline", event)
+	}
+	if _, ok := event["status_message"]; ok {
+		t.Errorf("invisible user's event has a status message: %v", event)
+	}
+
+	// A new status message while invisible is not announced
+	status = "still here"
+	if err := updatePresence(alice, "", &status); err != nil {
+		t.Fatal(err)
+	}
+	if event := aliceEvent(); event != nil {
+		t.Errorf("status change while invisible sent %v", event)
+	}
+
+	users, err := fetchUserList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryFor := func(viewerID int) map[string]interface{} {
+		list := userListMessage(viewerID, users, h.connectedPresence())
+		for _, entry := range list["users"].([]map[string]interface{}) {
+			if entry["id"] == alice {
+				return entry
+			}
+		}
+		t.Fatal("alice missing from the user list")
+		return nil
+	}
+	if entry := entryFor(bob); entry["presence"] != "offline" || entry["status_message"] != nil {
+		t.Errorf("user list shows an invisible user as %v", entry)
+	}
+	if entry := entryFor(alice); entry["status_message"] != "still here" {
+		t.Errorf("invisible user's own entry = %v, want their status message", entry)
+	}
+}
@@ -0,0 +1,181 @@
+package srco
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Version of the WebSocket message protocol spoken by this server
+const protocolVersion = 1
+
+// envelope is a frame received from a WebSocket client
+type envelope struct {
+	Version   int             `json:"v"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+}
+
+type chatMessageContent struct {
+	To           int    `json:"to"`
+	Content      string `json:"content"`
+	AttachmentID int    `json:"attachment_id,omitempty"`
+}
+
+type typingStatusContent struct {
+	To       int  `json:"to"`
+	IsTyping bool `json:"isTyping"`
+}
+
+type messageAckContent struct {
+	MessageID int    `json:"message_id"`
+	Status    string `json:"status"`
+}
+
+type markReadContent struct {
+	PartnerID  int `json:"partner_id"`
+	LastReadID int `json:"last_read_id"`
+}
+
+type roomMessageContent struct {
+	RoomID       int    `json:"room_id"`
+	Content      string `json:"content"`
+	AttachmentID int    `json:"attachment_id,omitempty"`
+}
+
+type roomTypingContent struct {
+	RoomID   int  `json:"room_id"`
+	IsTyping bool `json:"isTyping"`
+}
+
+type editMessageContent struct {
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+type deleteMessageContent struct {
+	MessageID int `json:"message_id"`
+}
+
+type setPresenceContent struct {
+	Presence      string  `json:"presence"`
+	StatusMessage *string `json:"status_message"`
+}
+
+// errorFrame is sent back to the client when one of its frames is rejected
+type errorFrame struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// protocolError is returned by message handlers for problems the client should be told about
+type protocolError struct {
+	code    string
+	message string
+}
+
+func (e *protocolError) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errBadFrame           = &protocolError{"bad_frame", "Frame is not a valid message envelope"}
+	errUnsupportedVersion = &protocolError{"unsupported_version", "Protocol version is not supported"}
+	errUnknownType        = &protocolError{"unknown_type", "Unknown message type"}
+	errInvalidContent     = &protocolError{"invalid_content", "Message content is invalid"}
+	errInternal           = &protocolError{"internal_error", "Message could not be processed"}
+	errNotRoomMember      = &protocolError{"not_room_member", "You are not a member of this room"}
+	errMessageNotEditable = &protocolError{"not_editable", "Message can no longer be changed"}
+	errBlocked            = &protocolError{"blocked", "You cannot message this user"}
+	errInvalidAttachment  = &protocolError{"invalid_attachment", "Attachment not found or already used"}
+	errMessageTooLong     = &protocolError{"message_too_long", "Message is too long"}
+)
+
+// messageHandler handles one decoded frame received from c
+type messageHandler func(c *client, env *envelope) error
+
+// messageHandlers maps each message type to its handler
+var messageHandlers = map[string]messageHandler{
+	"requestUserList": handleRequestUserList,
+	"chat_message":    handleChatMessage,
+	"typing_status":   handleTypingStatus,
+	"message_ack":     handleMessageAck,
+	"mark_read":       handleMarkRead,
+	"room_message":    handleRoomMessage,
+	"room_typing":     handleRoomTyping,
+	"edit_message":    handleEditMessage,
+	"delete_message":  handleDeleteMessage,
+	"set_presence":    handleSetPresence,
+}
+
+// decodeEnvelope parses a raw frame. Frames without a version are treated as
+// version 1, which is what clients sent before the field existed.
+func decodeEnvelope(data []byte) (*envelope, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, errBadFrame
+	}
+	if env.Version == 0 {
+		env.Version = 1
+	}
+	if env.Version != protocolVersion {
+		return &env, errUnsupportedVersion
+	}
+	if env.Type == "" {
+		return &env, errBadFrame
+	}
+	return &env, nil
+}
+
+// decodeContent unmarshals the envelope content into v
+func decodeContent(env *envelope, v interface{}) error {
+	if len(env.Content) == 0 {
+		return errInvalidContent
+	}
+	if err := json.Unmarshal(env.Content, v); err != nil {
+		return errInvalidContent
+	}
+	return nil
+}
+
+// dispatch decodes a frame from c and runs the handler registered for its type,
+// replying with an error frame if anything goes wrong
+func dispatch(c *client, data []byte) {
+	env, err := decodeEnvelope(data)
+
+	// Frames are rate limited per type; unusable frames share one bucket
+	limitKey := "invalid"
+	var handler messageHandler
+	if err == nil {
+		var ok bool
+		if handler, ok = messageHandlers[env.Type]; ok {
+			limitKey = env.Type
+		} else {
+			err = errUnknownType
+		}
+	}
+	if !checkRateLimit(c, limitKey, env) {
+		return
+	}
+
+	if err == nil {
+		err = handler(c, env)
+	}
+	if err == nil {
+		return
+	}
+
+	perr, ok := err.(*protocolError)
+	if !ok {
+		log.Printf("Error handling WebSocket message from user %d: %v", c.userID, err)
+		perr = errInternal
+	}
+
+	frame := errorFrame{Type: "error", Code: perr.code, Message: perr.message}
+	if env != nil {
+		frame.RequestID = env.RequestID
+	}
+	c.enqueue(frame)
+}
@@ -0,0 +1,88 @@
+package srco
+
+import "testing"
+
+func FuzzDecodeEnvelope(f *testing.F) {
+	for _, seed := range []string{
+		`{"type":"chat_message","content":{"to":2,"content":"hi"}}`,
+		`{"v":1,"type":"typing_status","request_id":"r1","content":{"to":2,"isTyping":true}}`,
+		`{"v":2,"type":"chat_message"}`,
+		`{"type":""}`,
+		`{"content":null}`,
+		`[]`,
+		`null`,
+		`{`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		env, err := decodeEnvelope(data)
+		if err != nil {
+			if _, ok := err.(*protocolError); !ok {
+				t.Fatalf("error %v is not a protocolError", err)
+			}
+			return
+		}
+		if env.Version != protocolVersion || env.Type == "" {
+			t.Fatalf("accepted envelope %+v", env)
+		}
+	})
+}
+
+// Whatever a client sends, dispatch answers or ignores it without panicking
+func FuzzDispatch(f *testing.F) {
+	openTestDB(f)
+	previous := blobStore
+	blobStore = NewMemoryBlobStore()
+	f.Cleanup(func() { blobStore = previous })
+
+	alice := createTestUser(f, "alice")
+	bob := createTestUser(f, "bob")
+	if _, err := db.Exec("INSERT INTO chat_messages (from_id, to_id, content) VALUES (?, ?, 'hi')", alice, bob); err != nil {
+		f.Fatal(err)
+	}
+
+	seeds := map[string][]string{
+		"requestUserList": {`null`},
+		"chat_message":    {`{"to":2,"content":"hi"}`, `{"to":2,"content":"","attachment_id":1}`},
+		"typing_status":   {`{"to":2,"isTyping":true}`},
+		"message_ack":     {`{"message_id":1,"status":"read"}`},
+		"mark_read":       {`{"partner_id":2,"last_read_id":1}`},
+		"room_message":    {`{"room_id":1,"content":"hi"}`},
+		"room_typing":     {`{"room_id":1,"isTyping":true}`},
+		"edit_message":    {`{"message_id":1,"content":"edited"}`},
+		"delete_message":  {`{"message_id":1}`},
+		"set_presence":    {`{"presence":"dnd","status_message":"away"}`},
+	}
+	for msgType := range messageHandlers {
+		if len(seeds[msgType]) == 0 {
+			f.Fatalf("no fuzz seed for message type %q", msgType)
+		}
+		for _, content := range seeds[msgType] {
+			f.Add(msgType, []byte(content))
+			f.Add(msgType, []byte(`"`+content+`"`))
+		}
+		f.Add(msgType, []byte(`[]`))
+		f.Add(msgType, []byte(`{"to":-1,"room_id":-1,"message_id":-1}`))
+	}
+
+	f.Fuzz(func(t *testing.T, msgType string, content []byte) {
+		// Each input starts with full buckets so handlers are reached, not just the limiter
+		rateLimiters.Lock()
+		delete(rateLimiters.users, alice)
+		rateLimiters.Unlock()
+
+		c := newClient(defaultHub, alice, nil)
+		env := &envelope{Type: msgType, Content: content}
+		dispatch(c, []byte(`{"type":"`+msgType+`","content":`+string(content)+`}`))
+		drain(c)
+
+		// Handlers must also cope with content that is not valid JSON at all
+		if handler, ok := messageHandlers[msgType]; ok {
+			handler(c, env)
+			drain(c)
+		}
+	})
+}
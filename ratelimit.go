@@ -0,0 +1,190 @@
+package srco
+
+import (
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// rateLimit is a token bucket: Burst frames at once, refilled at Rate per second
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+var (
+	// Limits per WebSocket message type, shared by all of a user's connections
+	chatRateLimits = map[string]rateLimit{
+		"chat_message":  {Rate: 1, Burst: 5},
+		"room_message":  {Rate: 1, Burst: 5},
+		"edit_message":  {Rate: 0.5, Burst: 3},
+		"typing_status": {Rate: 2, Burst: 5},
+		"room_typing":   {Rate: 2, Burst: 5},
+		"invalid":       {Rate: 0.5, Burst: 3},
+	}
+
+	// Limit for message types not listed in chatRateLimits
+	defaultRateLimit = rateLimit{Rate: 5, Burst: 10}
+
+	// Longest chat message content, in characters
+	maxChatMessageLength = 2000
+
+	// A user throttled this many times within abuseWindow is disconnected
+	abuseThreshold = 20
+	abuseWindow    = 10 * time.Second
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type userLimiter struct {
+	sync.Mutex
+	buckets    map[string]*tokenBucket
+	violations []time.Time
+	lastUsed   time.Time
+}
+
+var rateLimiters = struct {
+	sync.Mutex
+	users map[int]*userLimiter
+}{users: make(map[int]*userLimiter)}
+
+func limiterFor(userID int) *userLimiter {
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+
+	l, ok := rateLimiters.users[userID]
+	if !ok {
+		l = &userLimiter{buckets: make(map[string]*tokenBucket), lastUsed: time.Now()}
+		rateLimiters.users[userID] = l
+	}
+	return l
+}
+
+// limiterIdleTime is how long a limiter stays after its last use. Limiters
+// outlive connections, so reconnecting does not reset a flooder's buckets or
+// abuse count; after this long every bucket is full again and every violation
+// is outside abuseWindow, so dropping the limiter changes nothing.
+func limiterIdleTime() time.Duration {
+	refill := defaultRateLimit.refillTime()
+	for _, limit := range chatRateLimits {
+		if t := limit.refillTime(); t > refill {
+			refill = t
+		}
+	}
+	return abuseWindow + refill
+}
+
+// refillTime is how long an empty bucket takes to fill up
+func (l rateLimit) refillTime() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// startRateLimiterSweeper drops idle limiters in the background
+func startRateLimiterSweeper() {
+	runEvery(limiterIdleTime(), func() {
+		sweepRateLimiters(time.Now())
+	})
+}
+
+func sweepRateLimiters(now time.Time) {
+	cutoff := now.Add(-limiterIdleTime())
+
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+
+	for userID, l := range rateLimiters.users {
+		l.Lock()
+		idle := l.lastUsed.Before(cutoff)
+		l.Unlock()
+		if idle {
+			delete(rateLimiters.users, userID)
+		}
+	}
+}
+
+// allow takes a token for msgType. When none is left it returns how long until
+// the next one, and whether the user has been throttled often enough to count as abusive.
+func (l *userLimiter) allow(msgType string, now time.Time) (bool, time.Duration, bool) {
+	limit, ok := chatRateLimits[msgType]
+	if !ok {
+		limit = defaultRateLimit
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.lastUsed = now
+	b, ok := l.buckets[msgType]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[msgType] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, false
+	}
+
+	// Keep only the violations inside the window
+	cutoff := now.Add(-abuseWindow)
+	recent := l.violations[:0]
+	for _, t := range l.violations {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	l.violations = append(recent, now)
+
+	retryAfter := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, retryAfter, len(l.violations) >= abuseThreshold
+}
+
+// checkRateLimit reports whether a frame of the given type from c may be handled.
+// Throttled frames get a warning back; sustained abuse closes the connection.
+func checkRateLimit(c *client, msgType string, env *envelope) bool {
+	ok, retryAfter, abusive := limiterFor(c.userID).allow(msgType, time.Now())
+	if ok {
+		return true
+	}
+
+	if abusive {
+		log.Printf("Disconnecting user %d for flooding", c.userID)
+		if c.conn != nil {
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many messages"),
+				time.Now().Add(writeWait))
+		}
+		c.close()
+		return false
+	}
+
+	warning := map[string]interface{}{
+		"type":           "rate_limited",
+		"message_type":   msgType,
+		"retry_after_ms": retryAfter.Milliseconds(),
+	}
+	if env != nil {
+		warning["request_id"] = env.RequestID
+	}
+	c.enqueue(warning)
+	return false
+}
+
+func checkMessageLength(content string) error {
+	if utf8.RuneCountInString(content) > maxChatMessageLength {
+		return errMessageTooLong
+	}
+	return nil
+}
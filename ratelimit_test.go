@@ -0,0 +1,163 @@
+package srco
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	l := &userLimiter{buckets: make(map[string]*tokenBucket)}
+	limit := chatRateLimits["chat_message"]
+	now := time.Now()
+
+	for i := 0; i < limit.Burst; i++ {
+		if ok, _, _ := l.allow("chat_message", now); !ok {
+			t.Fatalf("frame %d of the burst was throttled", i+1)
+		}
+	}
+	ok, retryAfter, abusive := l.allow("chat_message", now)
+	if ok || abusive {
+		t.Fatalf("frame past the burst: ok=%v abusive=%v, want throttled only", ok, abusive)
+	}
+	if want := time.Duration(float64(time.Second) / limit.Rate); retryAfter != want {
+		t.Errorf("retryAfter = %v, want %v", retryAfter, want)
+	}
+
+	// Other message types have their own bucket
+	if ok, _, _ := l.allow("typing_status", now); !ok {
+		t.Error("typing_status throttled by chat_message bucket")
+	}
+
+	if ok, _, _ := l.allow("chat_message", now.Add(retryAfter)); !ok {
+		t.Error("frame after retryAfter was throttled")
+	}
+}
+
+func TestLimiterFlagsSustainedAbuse(t *testing.T) {
+	l := &userLimiter{buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+
+	var abusive bool
+	for i := 0; i < chatRateLimits["chat_message"].Burst+abuseThreshold; i++ {
+		_, _, abusive = l.allow("chat_message", now)
+	}
+	if !abusive {
+		t.Fatalf("%d throttled frames within abuseWindow not flagged as abuse", abuseThreshold)
+	}
+
+	// Violations older than the window no longer count
+	l = &userLimiter{buckets: make(map[string]*tokenBucket)}
+	for i := 0; i < chatRateLimits["chat_message"].Burst+abuseThreshold-1; i++ {
+		l.allow("chat_message", now)
+	}
+	if _, _, abusive := l.allow("chat_message", now.Add(abuseWindow+time.Millisecond)); abusive {
+		t.Error("violations outside abuseWindow still counted")
+	}
+}
+
+// Reconnecting, here by opening a new stream session, keeps the throttled state
+func TestRateLimitSurvivesReconnect(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	frame := []byte(`{"type":"typing_status","content":{"to":` + strconv.Itoa(bob) + `,"isTyping":true}}`)
+
+	s, err := openStreamSession(alice, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= chatRateLimits["typing_status"].Burst; i++ {
+		dispatch(s.client, frame)
+	}
+	if !receivedType(s.client, "rate_limited") {
+		t.Fatal("frame past the burst was not throttled")
+	}
+	closeStreamSession(s)
+
+	s, err = openStreamSession(alice, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeStreamSession(s)
+	drain(s.client)
+	dispatch(s.client, frame)
+	if !receivedType(s.client, "rate_limited") {
+		t.Error("reconnecting reset the rate limit")
+	}
+}
+
+func TestSweepRateLimiters(t *testing.T) {
+	now := time.Now()
+	limiterFor(-1).allow("chat_message", now.Add(-limiterIdleTime()-time.Second))
+	limiterFor(-2).allow("chat_message", now)
+
+	sweepRateLimiters(now)
+
+	rateLimiters.Lock()
+	_, idleKept := rateLimiters.users[-1]
+	_, activeKept := rateLimiters.users[-2]
+	delete(rateLimiters.users, -2)
+	rateLimiters.Unlock()
+
+	if idleKept {
+		t.Error("idle limiter was not swept")
+	}
+	if !activeKept {
+		t.Error("active limiter was swept")
+	}
+}
+
+// receivedType drains the frames queued for c and reports whether one had the given type
+func receivedType(c *client, frameType string) bool {
+	found := false
+	for _, msg := range drain(c) {
+		data, _ := json.Marshal(msg)
+		var frame struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(data, &frame)
+		if frame.Type == frameType {
+			found = true
+		}
+	}
+	return found
+}
+
+// connectTestClient connects a client without a conn for userID to defaultHub
+// and discards the frames sent on connecting
+func connectTestClient(t *testing.T, userID int) *client {
+	t.Helper()
+
+	c := newClient(defaultHub, userID, nil)
+	defaultHub.connect(c)
+	t.Cleanup(func() { defaultHub.disconnect(c) })
+	drain(c)
+	return c
+}
+
+// receivedFrames drains the frames queued for c, decoded as JSON objects
+func receivedFrames(c *client) []map[string]interface{} {
+	var frames []map[string]interface{}
+	for _, msg := range drain(c) {
+		data, _ := json.Marshal(msg)
+		var frame map[string]interface{}
+		json.Unmarshal(data, &frame)
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// drain returns the frames queued for c without waiting for more
+func drain(c *client) []interface{} {
+	var frames []interface{}
+	for {
+		select {
+		case msg := <-c.send:
+			frames = append(frames, msg)
+		default:
+			return frames
+		}
+	}
+}
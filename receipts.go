@@ -0,0 +1,147 @@
+package srco
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// markConversationRead moves the user's read marker for the conversation with
+// partnerID forward to lastReadID, or to the latest message received from the
+// partner if that is lower. If the marker moved it notifies the partner and the
+// user's other connections; skip, if set, is the connection the request came from.
+func markConversationRead(h *hub, userID, partnerID, lastReadID int, skip *client) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var latestID, currentID int
+	err = tx.QueryRow("SELECT COALESCE(MAX(id), 0) FROM chat_messages WHERE from_id = ? AND to_id = ?",
+		partnerID, userID).Scan(&latestID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow("SELECT COALESCE(MAX(last_read_id), 0) FROM chat_read_markers WHERE user_id = ? AND partner_id = ?",
+		userID, partnerID).Scan(&currentID)
+	if err != nil {
+		return err
+	}
+	if lastReadID > latestID {
+		lastReadID = latestID
+	}
+
+	// The marker only ever moves forward
+	if lastReadID <= currentID {
+		return nil
+	}
+	_, err = tx.Exec(`
+		INSERT INTO chat_read_markers (user_id, partner_id, last_read_id, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, partner_id) DO UPDATE SET
+			last_read_id = MAX(last_read_id, excluded.last_read_id),
+			updated_at = CURRENT_TIMESTAMP`,
+		userID, partnerID, lastReadID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE chat_messages 
+		SET delivered_at = COALESCE(delivered_at, CURRENT_TIMESTAMP), read_at = CURRENT_TIMESTAMP 
+		WHERE from_id = ? AND to_id = ? AND id <= ? AND read_at IS NULL`,
+		partnerID, userID, lastReadID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	h.sendToUser(partnerID, map[string]interface{}{
+		"type":         "read",
+		"reader_id":    userID,
+		"last_read_id": lastReadID,
+	})
+	h.sendToUserExcept(userID, skip, map[string]interface{}{
+		"type":         "read_marker",
+		"partner_id":   partnerID,
+		"last_read_id": lastReadID,
+	})
+	return nil
+}
+
+// unreadCounts returns, per conversation partner, how many messages the user
+// has received after their read marker
+func unreadCounts(userID int) map[int]int {
+	counts := make(map[int]int)
+	rows, err := db.Query(`
+		SELECT cm.from_id, COUNT(*)
+		FROM chat_messages cm
+		LEFT JOIN chat_read_markers m ON m.user_id = cm.to_id AND m.partner_id = cm.from_id
+		WHERE cm.to_id = ? AND cm.id > COALESCE(m.last_read_id, 0)
+		GROUP BY cm.from_id`, userID)
+	if err != nil {
+		log.Printf("Error counting unread messages: %v", err)
+		return counts
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var partnerID, count int
+		if err := rows.Scan(&partnerID, &count); err != nil {
+			continue
+		}
+		counts[partnerID] = count
+	}
+	return counts
+}
+
+func handleMarkRead(c *client, env *envelope) error {
+	var content markReadContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+	if content.PartnerID <= 0 || content.LastReadID <= 0 {
+		return errInvalidContent
+	}
+
+	if err := markConversationRead(c.hub, c.userID, content.PartnerID, content.LastReadID, c); err != nil {
+		log.Printf("Error marking conversation read: %v", err)
+		return errInternal
+	}
+	return nil
+}
+
+func markReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		UserID     int `json:"user_id"`
+		PartnerID  int `json:"partner_id"`
+		LastReadID int `json:"last_read_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if request.UserID <= 0 || request.PartnerID <= 0 || request.LastReadID <= 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := markConversationRead(defaultHub, request.UserID, request.PartnerID, request.LastReadID, nil); err != nil {
+		log.Printf("Error marking conversation read: %v", err)
+		http.Error(w, "Error marking conversation read", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
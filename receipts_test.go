@@ -0,0 +1,60 @@
+package srco
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMarkConversationRead(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	a := connectTestClient(t, alice)
+	b := connectTestClient(t, bob)
+
+	send := func() int {
+		result, err := db.Exec("INSERT INTO chat_messages (from_id, to_id, content) VALUES (?, ?, 'hi')", alice, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		return int(id)
+	}
+	first := send()
+	second := send()
+
+	markRead := func(lastReadID int) {
+		dispatch(b, []byte(`{"type":"mark_read","content":{"partner_id":`+strconv.Itoa(alice)+
+			`,"last_read_id":`+strconv.Itoa(lastReadID)+`}}`))
+	}
+	readEvents := func() []float64 {
+		var ids []float64
+		for _, frame := range receivedFrames(a) {
+			if frame["type"] == "read" {
+				ids = append(ids, frame["last_read_id"].(float64))
+			}
+		}
+		return ids
+	}
+
+	// A marker past the conversation is clamped to its latest message
+	markRead(1e9)
+	if got := readEvents(); len(got) != 1 || got[0] != float64(second) {
+		t.Fatalf("read events = %v, want one for message %d", got, second)
+	}
+	send()
+	if n := unreadCounts(bob)[alice]; n != 1 {
+		t.Errorf("unread after a new message = %d, want 1", n)
+	}
+
+	// Going backwards changes nothing and tells no one
+	markRead(first)
+	if got := readEvents(); len(got) != 0 {
+		t.Errorf("read events for an older marker = %v, want none", got)
+	}
+	var marker int
+	db.QueryRow("SELECT last_read_id FROM chat_read_markers WHERE user_id = ? AND partner_id = ?", bob, alice).Scan(&marker)
+	if marker != second {
+		t.Errorf("stored marker = %d, want %d", marker, second)
+	}
+}
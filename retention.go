@@ -0,0 +1,185 @@
+package srco
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// How long chat messages are kept unless the conversation sets its own
+	// retention; zero keeps them forever
+	chatRetention time.Duration
+
+	// How often the chat retention job runs
+	chatRetentionInterval = time.Hour
+
+	// Longest retention a conversation can choose, in days
+	maxRetentionDays = 3650
+)
+
+// conversationRetention is the retention set on one conversation; zero days means the global default applies
+type conversationRetention struct {
+	RetentionDays int `json:"retention_days"`
+	DefaultDays   int `json:"default_days"`
+}
+
+func getChatRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	partnerID, _ := strconv.Atoi(r.URL.Query().Get("partner_id"))
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("room_id"))
+
+	var days sql.NullInt64
+	if roomID > 0 {
+		if _, ok := roomRole(roomID, userID); !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		err = db.QueryRow("SELECT retention_days FROM rooms WHERE id = ?", roomID).Scan(&days)
+	} else if partnerID > 0 {
+		user1, user2 := conversationPair(userID, partnerID)
+		err = db.QueryRow("SELECT retention_days FROM chat_retention WHERE user1_id = ? AND user2_id = ?",
+			user1, user2).Scan(&days)
+	} else {
+		http.Error(w, "Partner or room is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversationRetention{
+		RetentionDays: int(days.Int64),
+		DefaultDays:   int(chatRetention / (24 * time.Hour)),
+	})
+}
+
+// setChatRetentionHandler sets how long a conversation's messages are kept.
+// Either participant may change a private chat; only the owner may change a room.
+// Zero days goes back to the global retention.
+func setChatRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		UserID    int `json:"user_id"`
+		PartnerID int `json:"partner_id"`
+		RoomID    int `json:"room_id"`
+		Days      int `json:"retention_days"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if request.Days < 0 || request.Days > maxRetentionDays {
+		http.Error(w, "Invalid retention period", http.StatusBadRequest)
+		return
+	}
+
+	event := map[string]interface{}{
+		"type":           "retention_changed",
+		"retention_days": request.Days,
+		"changed_by":     request.UserID,
+	}
+
+	var err error
+	if request.RoomID > 0 {
+		if role, ok := roomRole(request.RoomID, request.UserID); !ok || role != "owner" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, err = db.Exec("UPDATE rooms SET retention_days = NULLIF(?, 0) WHERE id = ?", request.Days, request.RoomID)
+		event["room_id"] = request.RoomID
+	} else if request.PartnerID > 0 {
+		user1, user2 := conversationPair(request.UserID, request.PartnerID)
+		if request.Days == 0 {
+			_, err = db.Exec("DELETE FROM chat_retention WHERE user1_id = ? AND user2_id = ?", user1, user2)
+		} else {
+			_, err = db.Exec(`
+				INSERT INTO chat_retention (user1_id, user2_id, retention_days, updated_by, updated_at)
+				VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+				ON CONFLICT(user1_id, user2_id) DO UPDATE SET
+					retention_days = excluded.retention_days,
+					updated_by = excluded.updated_by,
+					updated_at = excluded.updated_at`,
+				user1, user2, request.Days, request.UserID)
+		}
+		event["partner_id"] = request.UserID
+	} else {
+		http.Error(w, "Partner or room is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting chat retention: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Let the other participants know their messages will now expire differently
+	if request.RoomID > 0 {
+		defaultHub.sendToRoom(request.RoomID, nil, event)
+	} else {
+		defaultHub.sendToUser(request.PartnerID, event)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// conversationPair orders two user IDs the way chat_retention stores them
+func conversationPair(a, b int) (int, int) {
+	if a < b {
+		return a, b
+	}
+	return b, a
+}
+
+// startChatRetention runs purgeExpiredMessages in the background every chatRetentionInterval
+func startChatRetention() {
+	runEvery(chatRetentionInterval, func() {
+		if err := purgeExpiredMessages(); err != nil {
+			log.Printf("Error purging expired chat messages: %v", err)
+		}
+	})
+}
+
+// purgeExpiredMessages hard-deletes chat messages older than their conversation's
+// retention, or chatRetention where none is set, together with their attachments
+func purgeExpiredMessages() error {
+	// A conversation's own retention wins over the global one; with neither the
+	// CASE yields NULL and the message is kept
+	expired := `
+		SELECT cm.id
+		FROM chat_messages cm
+		LEFT JOIN chat_retention cr ON cm.room_id IS NULL
+			AND cr.user1_id = MIN(cm.from_id, cm.to_id) AND cr.user2_id = MAX(cm.from_id, cm.to_id)
+		LEFT JOIN rooms r ON r.id = cm.room_id
+		WHERE cm.created_at < CASE
+			WHEN COALESCE(cr.retention_days, r.retention_days, 0) > 0
+				THEN datetime('now', '-' || COALESCE(cr.retention_days, r.retention_days) || ' days')
+			WHEN ? > 0 THEN datetime('now', ?)
+		END`
+	args := []interface{}{int64(chatRetention.Seconds()), sqliteAgo(chatRetention)}
+
+	result, err := deleteWithAttachments("message_id IN ("+expired+")", args,
+		"DELETE FROM chat_messages WHERE id IN ("+expired+")")
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Purged %d expired chat messages", n)
+	}
+	return nil
+}
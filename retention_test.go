@@ -0,0 +1,153 @@
+package srco
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeExpiredMessages(t *testing.T) {
+	openTestDB(t)
+	previous := blobStore
+	blobStore = NewMemoryBlobStore()
+	t.Cleanup(func() { blobStore = previous })
+	previousRetention := chatRetention
+	chatRetention = 24 * time.Hour
+	t.Cleanup(func() { chatRetention = previousRetention })
+
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	insert := func(age string) int64 {
+		result, err := db.Exec(`
+			INSERT INTO chat_messages (from_id, to_id, content, created_at)
+			VALUES (?, ?, 'hi', datetime('now', ?))`, alice, bob, age)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		return id
+	}
+	old := insert("-2 days")
+	recent := insert("-1 hours")
+
+	blobStore.Put("file", strings.NewReader("data"))
+	_, err := db.Exec(`
+		INSERT INTO attachments (user_id, message_id, filename, content_type, size, blob_key)
+		VALUES (?, ?, 'a.txt', 'text/plain', 4, 'file')`, alice, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := purgeExpiredMessages(); err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining []int64
+	rows, err := db.Query("SELECT id FROM chat_messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		remaining = append(remaining, id)
+	}
+	rows.Close()
+	if len(remaining) != 1 || remaining[0] != recent {
+		t.Errorf("messages left = %v, want only %d", remaining, recent)
+	}
+	if _, err := blobStore.Get("file"); err != ErrBlobNotFound {
+		t.Errorf("attachment blob of expired message still stored (err %v)", err)
+	}
+}
+
+// A conversation's or room's own retention wins over the global one in both directions
+func TestConversationRetentionOverrides(t *testing.T) {
+	openTestDB(t)
+	previousRetention := chatRetention
+	chatRetention = 7 * 24 * time.Hour
+	t.Cleanup(func() { chatRetention = previousRetention })
+
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	dave := createTestUser(t, "dave")
+
+	w := postJSON(createRoomHandler, "/rooms", map[string]interface{}{
+		"user_id": alice, "name": "room", "member_ids": []int{bob},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create room = %d %s", w.Code, w.Body)
+	}
+	var room Room
+	json.NewDecoder(w.Body).Decode(&room)
+
+	setRetention := func(request map[string]interface{}) {
+		t.Helper()
+		if w := postJSON(setChatRetentionHandler, "/chat/retention", request); w.Code != http.StatusOK {
+			t.Fatalf("set retention %v = %d %s", request, w.Code, w.Body)
+		}
+	}
+	// Shorter than the global retention for alice and bob, longer for alice and carol
+	setRetention(map[string]interface{}{"user_id": bob, "partner_id": alice, "retention_days": 1})
+	setRetention(map[string]interface{}{"user_id": alice, "partner_id": carol, "retention_days": 30})
+	setRetention(map[string]interface{}{"user_id": alice, "room_id": room.ID, "retention_days": 1})
+	if w := postJSON(setChatRetentionHandler, "/chat/retention", map[string]interface{}{
+		"user_id": bob, "room_id": room.ID, "retention_days": 30,
+	}); w.Code != http.StatusUnauthorized {
+		t.Errorf("room retention set by a member = %d, want 401", w.Code)
+	}
+
+	insert := func(from, to, roomID int, age string) int64 {
+		t.Helper()
+		var toID, inRoom interface{}
+		if roomID != 0 {
+			inRoom = roomID
+		} else {
+			toID = to
+		}
+		result, err := db.Exec(`
+			INSERT INTO chat_messages (from_id, to_id, room_id, content, created_at)
+			VALUES (?, ?, ?, 'hi', datetime('now', ?))`, from, toID, inRoom, age)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		return id
+	}
+	expired := []int64{
+		insert(alice, bob, 0, "-2 days"),
+		insert(bob, alice, 0, "-2 days"),
+		insert(alice, 0, room.ID, "-2 days"),
+		insert(alice, dave, 0, "-8 days"),
+	}
+	kept := []int64{
+		insert(alice, bob, 0, "-1 hours"),
+		insert(alice, 0, room.ID, "-1 hours"),
+		insert(carol, alice, 0, "-8 days"),
+		insert(alice, dave, 0, "-2 days"),
+	}
+
+	if err := purgeExpiredMessages(); err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(id int64) bool {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM chat_messages WHERE id = ?", id).Scan(&n)
+		return n == 1
+	}
+	for _, id := range expired {
+		if exists(id) {
+			t.Errorf("message %d outlived its retention", id)
+		}
+	}
+	for _, id := range kept {
+		if !exists(id) {
+			t.Errorf("message %d purged within its retention", id)
+		}
+	}
+}
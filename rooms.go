@@ -0,0 +1,488 @@
+package srco
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		UserID    int    `json:"user_id"`
+		Name      string `json:"name"`
+		MemberIDs []int  `json:"member_ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		http.Error(w, "Room name is required", http.StatusBadRequest)
+		return
+	}
+
+	blocked := blockedUserIDs(request.UserID)
+	for _, memberID := range request.MemberIDs {
+		if blocked[memberID] {
+			http.Error(w, "You cannot add a user you have blocked or who has blocked you", http.StatusForbidden)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Error creating room", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO rooms (name, owner_id) VALUES (?, ?)", request.Name, request.UserID)
+	if err != nil {
+		http.Error(w, "Error creating room", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	roomID := int(id)
+
+	_, err = tx.Exec("INSERT INTO room_members (room_id, user_id, role) VALUES (?, ?, 'owner')", roomID, request.UserID)
+	if err != nil {
+		http.Error(w, "Error creating room", http.StatusInternalServerError)
+		return
+	}
+	for _, memberID := range request.MemberIDs {
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO room_members (room_id, user_id, role)
+			SELECT ?, id, 'member' FROM users WHERE id = ?`, roomID, memberID)
+		if err != nil {
+			http.Error(w, "Error creating room", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Error creating room", http.StatusInternalServerError)
+		return
+	}
+
+	room, err := getRoom(roomID)
+	if err != nil {
+		log.Printf("Error fetching room: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	defaultHub.sendToRoom(roomID, nil, map[string]interface{}{
+		"type": "room_created",
+		"room": room,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(room)
+}
+
+func getRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT r.id
+		FROM rooms r
+		JOIN room_members m ON m.room_id = r.id
+		WHERE m.user_id = ?
+		ORDER BY r.name`, userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var roomIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			roomIDs = append(roomIDs, id)
+		}
+	}
+	rows.Close()
+
+	rooms := []Room{}
+	for _, id := range roomIDs {
+		room, err := getRoom(id)
+		if err != nil {
+			log.Printf("Error fetching room: %v", err)
+			continue
+		}
+		rooms = append(rooms, *room)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rooms)
+}
+
+func inviteToRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		RoomID    int `json:"room_id"`
+		UserID    int `json:"user_id"`
+		InviteeID int `json:"invitee_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// Any member can invite others
+	if _, ok := roomRole(request.RoomID, request.UserID); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if isBlockedBetween(request.UserID, request.InviteeID) {
+		http.Error(w, "You cannot add a user you have blocked or who has blocked you", http.StatusForbidden)
+		return
+	}
+
+	result, err := db.Exec(`
+		INSERT OR IGNORE INTO room_members (room_id, user_id, role)
+		SELECT ?, id, 'member' FROM users WHERE id = ?`, request.RoomID, request.InviteeID)
+	if err != nil {
+		http.Error(w, "Error inviting user", http.StatusInternalServerError)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		defaultHub.sendToRoom(request.RoomID, nil, map[string]interface{}{
+			"type":       "room_member_joined",
+			"room_id":    request.RoomID,
+			"user_id":    request.InviteeID,
+			"invited_by": request.UserID,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func leaveRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		RoomID int `json:"room_id"`
+		UserID int `json:"user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	role, ok := roomRole(request.RoomID, request.UserID)
+	if !ok {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	if err := removeRoomMember(request.RoomID, request.UserID, role == "owner"); err != nil {
+		log.Printf("Error leaving room: %v", err)
+		http.Error(w, "Error leaving room", http.StatusInternalServerError)
+		return
+	}
+
+	notifyRoomMemberLeft(request.RoomID, request.UserID, "left")
+	w.WriteHeader(http.StatusOK)
+}
+
+func kickFromRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		RoomID   int `json:"room_id"`
+		UserID   int `json:"user_id"`
+		MemberID int `json:"member_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// Only the owner can kick, and not themselves
+	if role, ok := roomRole(request.RoomID, request.UserID); !ok || role != "owner" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if request.MemberID == request.UserID {
+		http.Error(w, "Owners leave a room instead of kicking themselves", http.StatusBadRequest)
+		return
+	}
+	if _, ok := roomRole(request.RoomID, request.MemberID); !ok {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	if err := removeRoomMember(request.RoomID, request.MemberID, false); err != nil {
+		log.Printf("Error kicking room member: %v", err)
+		http.Error(w, "Error removing member", http.StatusInternalServerError)
+		return
+	}
+
+	notifyRoomMemberLeft(request.RoomID, request.MemberID, "kicked")
+	w.WriteHeader(http.StatusOK)
+}
+
+func getRoomHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("room_id"))
+	userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+	before, after, limit, err := parseHistoryCursor(r)
+	if err != nil {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := roomRole(roomID, userID); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messages, hasMore, err := fetchMessagePage("cm.room_id = ?", []interface{}{roomID}, before, after, limit)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": messages,
+		"has_more": hasMore,
+	})
+}
+
+func handleRoomMessage(c *client, env *envelope) error {
+	var content roomMessageContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+	if strings.TrimSpace(content.Content) == "" && content.AttachmentID == 0 {
+		return errInvalidContent
+	}
+	if err := checkMessageLength(content.Content); err != nil {
+		return err
+	}
+	if _, ok := roomRole(content.RoomID, c.userID); !ok {
+		return errNotRoomMember
+	}
+
+	var fromNick string
+	if err := db.QueryRow("SELECT nickname FROM users WHERE id = ?", c.userID).Scan(&fromNick); err != nil {
+		return err
+	}
+
+	message := ChatMessage{
+		FromID:    c.userID,
+		FromNick:  fromNick,
+		RoomID:    content.RoomID,
+		Content:   content.Content,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	if err := storeChatMessage(&message, content.AttachmentID); err != nil {
+		if _, ok := err.(*protocolError); ok {
+			return err
+		}
+		log.Printf("Error storing room message: %v", err)
+		return errInternal
+	}
+
+	c.enqueue(map[string]interface{}{
+		"type":       "chat_ack",
+		"request_id": env.RequestID,
+		"message_id": message.ID,
+		"timestamp":  message.Timestamp,
+	})
+
+	c.hub.sendToRoomFrom(message.RoomID, c, map[string]interface{}{
+		"type":    "room_message",
+		"message": message,
+	})
+	return nil
+}
+
+func handleRoomTyping(c *client, env *envelope) error {
+	var content roomTypingContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+	if _, ok := roomRole(content.RoomID, c.userID); !ok {
+		return errNotRoomMember
+	}
+
+	blocked := blockedUserIDs(c.userID)
+	event := map[string]interface{}{
+		"type":      "room_typing",
+		"room_id":   content.RoomID,
+		"from_id":   c.userID,
+		"is_typing": content.IsTyping,
+	}
+	for _, memberID := range roomMemberIDs(content.RoomID) {
+		if memberID != c.userID && !blocked[memberID] {
+			c.hub.sendToUser(memberID, event)
+		}
+	}
+	return nil
+}
+
+// roomRole returns the user's role in the room and whether they are a member at all
+func roomRole(roomID, userID int) (string, bool) {
+	var role string
+	err := db.QueryRow("SELECT role FROM room_members WHERE room_id = ? AND user_id = ?", roomID, userID).Scan(&role)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Database error: %v", err)
+		}
+		return "", false
+	}
+	return role, true
+}
+
+func roomMemberIDs(roomID int) []int {
+	rows, err := db.Query("SELECT user_id FROM room_members WHERE room_id = ?", roomID)
+	if err != nil {
+		log.Printf("Error fetching room members: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// sendToRoom queues msg for every connection of every member, except skip
+func (h *hub) sendToRoom(roomID int, skip *client, msg interface{}) {
+	for _, memberID := range roomMemberIDs(roomID) {
+		h.sendToUserExcept(memberID, skip, msg)
+	}
+}
+
+// sendToRoomFrom queues a message from c's user for every member of the room,
+// leaving out members with a block either way with the sender. Blocks made
+// after both joined the room still apply.
+func (h *hub) sendToRoomFrom(roomID int, c *client, msg interface{}) {
+	blocked := blockedUserIDs(c.userID)
+	for _, memberID := range roomMemberIDs(roomID) {
+		if !blocked[memberID] {
+			h.sendToUserExcept(memberID, c, msg)
+		}
+	}
+}
+
+func getRoom(roomID int) (*Room, error) {
+	var room Room
+	err := db.QueryRow("SELECT id, name, owner_id, created_at FROM rooms WHERE id = ?", roomID).Scan(
+		&room.ID, &room.Name, &room.OwnerID, &room.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT m.user_id, u.nickname, m.role
+		FROM room_members m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = ?
+		ORDER BY m.joined_at`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	room.Members = []RoomMember{}
+	for rows.Next() {
+		var member RoomMember
+		if err := rows.Scan(&member.UserID, &member.Nickname, &member.Role); err != nil {
+			continue
+		}
+		room.Members = append(room.Members, member)
+	}
+	return &room, nil
+}
+
+// removeRoomMember removes the user from the room. When the owner leaves,
+// ownership passes to the longest-standing remaining member.
+func removeRoomMember(roomID, userID int, wasOwner bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM room_members WHERE room_id = ? AND user_id = ?", roomID, userID); err != nil {
+		return err
+	}
+
+	if wasOwner {
+		var newOwnerID int
+		err := tx.QueryRow(`
+			SELECT user_id FROM room_members 
+			WHERE room_id = ? 
+			ORDER BY joined_at, user_id 
+			LIMIT 1`, roomID).Scan(&newOwnerID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			if _, err := tx.Exec("UPDATE room_members SET role = 'owner' WHERE room_id = ? AND user_id = ?", roomID, newOwnerID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("UPDATE rooms SET owner_id = ? WHERE id = ?", newOwnerID, roomID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// notifyRoomMemberLeft tells the remaining members and the removed user about the change
+func notifyRoomMemberLeft(roomID, userID int, reason string) {
+	event := map[string]interface{}{
+		"type":    "room_member_left",
+		"room_id": roomID,
+		"user_id": userID,
+		"reason":  reason,
+	}
+	defaultHub.sendToRoom(roomID, nil, event)
+	defaultHub.sendToUser(userID, event)
+}
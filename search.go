@@ -0,0 +1,161 @@
+package srco
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Messages shown on each side of a search match
+	searchContextSize = 2
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+
+	// Private-use characters marking matches in FTS snippets until the text is escaped
+	highlightStart = "\ue000"
+	highlightEnd   = "\ue001"
+)
+
+// Whether SQLite has FTS5; set by createChatSearchIndex
+var chatSearchEnabled bool
+
+// searchChatHandler runs a full-text search over the messages the user can see,
+// optionally limited to one conversation partner and a date range (YYYY-MM-DD,
+// inclusive). Each match comes with the messages around it so the client can
+// jump to that point in history.
+func searchChatHandler(w http.ResponseWriter, r *http.Request) {
+	if !chatSearchEnabled {
+		http.Error(w, "Chat search is not available on this server", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	userID, err := strconv.Atoi(query.Get("user_id"))
+	if err != nil {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	match := ftsQuery(query.Get("q"))
+	if match == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 && v <= maxSearchLimit {
+		limit = v
+	}
+
+	sqlQuery := `
+		SELECT cm.id, cm.from_id, COALESCE(cm.to_id, 0), COALESCE(cm.room_id, 0), cm.content, cm.created_at,
+		       u.nickname, COALESCE(cm.edited_at, ''),
+		       snippet(chat_messages_fts, 0, ?, ?, '…', 12)
+		FROM chat_messages_fts
+		JOIN chat_messages cm ON cm.id = chat_messages_fts.rowid
+		JOIN users u ON cm.from_id = u.id
+		WHERE chat_messages_fts MATCH ? AND cm.deleted_at IS NULL
+		AND (cm.from_id = ? OR cm.to_id = ? OR cm.room_id IN (SELECT room_id FROM room_members WHERE user_id = ?))`
+	args := []interface{}{highlightStart, highlightEnd, match, userID, userID, userID}
+
+	if partnerID, err := strconv.Atoi(query.Get("partner_id")); err == nil {
+		sqlQuery += " AND ((cm.from_id = ? AND cm.to_id = ?) OR (cm.from_id = ? AND cm.to_id = ?))"
+		args = append(args, userID, partnerID, partnerID, userID)
+	}
+	if from := query.Get("from"); from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+		sqlQuery += " AND cm.created_at >= date(?)"
+		args = append(args, from)
+	}
+	if to := query.Get("to"); to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+		sqlQuery += " AND cm.created_at < date(?, '+1 day')"
+		args = append(args, to)
+	}
+
+	sqlQuery += " ORDER BY cm.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(sqlQuery, args...)
+	if err != nil {
+		log.Printf("Search error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	results := []ChatSearchResult{}
+	for rows.Next() {
+		var result ChatSearchResult
+		msg := &result.Message
+		if err := rows.Scan(&msg.ID, &msg.FromID, &msg.ToID, &msg.RoomID, &msg.Content, &msg.Timestamp,
+			&msg.FromNick, &msg.EditedAt, &result.Highlight); err != nil {
+			log.Printf("Error scanning search result: %v", err)
+			continue
+		}
+		result.Highlight = highlightHTML(result.Highlight)
+		results = append(results, result)
+	}
+	rows.Close()
+
+	for i := range results {
+		addSearchContext(&results[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// addSearchContext loads the messages around a match from the same conversation
+func addSearchContext(result *ChatSearchResult) {
+	msg := result.Message
+	filter := "cm.room_id = ?"
+	args := []interface{}{msg.RoomID}
+	if msg.RoomID == 0 {
+		filter = "(cm.from_id = ? AND cm.to_id = ?) OR (cm.from_id = ? AND cm.to_id = ?)"
+		args = []interface{}{msg.FromID, msg.ToID, msg.ToID, msg.FromID}
+	}
+
+	before, _, err := fetchMessagePage(filter, append([]interface{}{}, args...), msg.ID, 0, searchContextSize)
+	if err != nil {
+		log.Printf("Error fetching search context: %v", err)
+	}
+	after, _, err := fetchMessagePage(filter, append([]interface{}{}, args...), 0, msg.ID, searchContextSize)
+	if err != nil {
+		log.Printf("Error fetching search context: %v", err)
+	}
+	result.ContextBefore = before
+	result.ContextAfter = after
+}
+
+// ftsQuery turns free text into an FTS5 query matching every word, with the
+// last word as a prefix so results update while typing. Quoting each word
+// keeps FTS5 operators in user input from being interpreted.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// highlightHTML escapes a snippet and turns the match markers into <mark> tags
+func highlightHTML(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightEnd, "</mark>")
+}
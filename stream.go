@@ -0,0 +1,374 @@
+package srco
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Server-Sent Events and long polling for clients whose proxies break WebSockets.
+// Each stream is a client without a conn registered with the hub like any other,
+// so users on different transports see the same events. Frames are sent with POST
+// to streamSendHandler and answered on the stream, exactly as over a WebSocket.
+//
+// Every long-poll response carries a cursor. Passing it as cursor on the next
+// poll confirms the events received; unconfirmed events are sent again, so a
+// response lost on the way to the client loses nothing.
+
+var (
+	// How long a long poll waits for events before returning empty
+	longPollTimeout = 25 * time.Second
+
+	// A long-poll session is closed when no poll arrives for this long
+	pollSessionTimeout = 60 * time.Second
+
+	// Most events returned by a single long poll
+	maxPollEvents = 100
+
+	// Number of events buffered for a long-poll session between polls
+	pollSendBuffer = 1024
+
+	streamSessions = struct {
+		sync.Mutex
+		sessions map[string]*streamSession
+	}{sessions: make(map[string]*streamSession)}
+)
+
+type streamSession struct {
+	id     string
+	client *client
+	// Long-poll sessions expire without polls; SSE sessions end with their request
+	polling  bool
+	lastPoll time.Time
+
+	// Serializes polls and guards unconfirmed and cursor
+	pollMu sync.Mutex
+
+	// Events taken from the client's queue that no poll has confirmed yet
+	unconfirmed []interface{}
+
+	// Number of events taken from the client's queue so far
+	cursor int
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// openStreamSession connects userID the same way handleWebSocket does
+func openStreamSession(userID int, polling bool, lastSeenID int) (*streamSession, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	s := &streamSession{
+		id:       id,
+		client:   newClient(defaultHub, userID, nil),
+		polling:  polling,
+		lastPoll: time.Now(),
+	}
+	// Events wait in the queue between polls, not only while a write is in progress
+	if polling {
+		s.client.send = make(chan interface{}, pollSendBuffer)
+	}
+
+	streamSessions.Lock()
+	streamSessions.sessions[id] = s
+	streamSessions.Unlock()
+
+	defaultHub.connect(s.client)
+	replayPendingMessages(s.client, lastSeenID)
+
+	// A client closed for falling behind is taken offline at once, not at the next poll
+	go func() {
+		<-s.client.done
+		closeStreamSession(s)
+	}()
+	return s, nil
+}
+
+// closeStreamSession disconnects the session's client; it is safe to call more than once
+func closeStreamSession(s *streamSession) {
+	streamSessions.Lock()
+	_, ok := streamSessions.sessions[s.id]
+	delete(streamSessions.sessions, s.id)
+	streamSessions.Unlock()
+	if !ok {
+		return
+	}
+
+	s.client.hub.disconnect(s.client)
+}
+
+// lookupStreamSession finds the session named in the request, if it belongs to the requesting user
+func lookupStreamSession(r *http.Request) (*streamSession, bool) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil {
+		return nil, false
+	}
+
+	streamSessions.Lock()
+	defer streamSessions.Unlock()
+
+	s, ok := streamSessions.sessions[r.URL.Query().Get("session")]
+	if !ok || s.client.userID != userID {
+		return nil, false
+	}
+	return s, true
+}
+
+// sseHandler streams events to the client as Server-Sent Events. The first
+// event names the session to pass to streamSendHandler.
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop nginx and similar proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	lastSeenID, _ := strconv.Atoi(r.URL.Query().Get("last_seen_id"))
+	s, err := openStreamSession(userID, false, lastSeenID)
+	if err != nil {
+		log.Printf("Error opening event stream: %v", err)
+		http.Error(w, "Failed to open stream", http.StatusInternalServerError)
+		return
+	}
+	defer closeStreamSession(s)
+
+	fmt.Fprintf(w, "event: session\ndata: {\"session\":%q}\n\n", s.id)
+	flusher.Flush()
+
+	// Comments keep idle proxies from timing out the connection
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	c := s.client
+	for {
+		select {
+		case msg := <-c.send:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				log.Printf("Error encoding event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// longPollHandler returns queued events, waiting up to longPollTimeout for the
+// first one. Without a session it opens one and returns the initial events.
+func longPollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var s *streamSession
+	resuming := r.URL.Query().Get("session") != ""
+	if !resuming {
+		userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+		if err != nil {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+		lastSeenID, _ := strconv.Atoi(r.URL.Query().Get("last_seen_id"))
+		if s, err = openStreamSession(userID, true, lastSeenID); err != nil {
+			log.Printf("Error opening poll session: %v", err)
+			http.Error(w, "Failed to open session", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		var ok bool
+		if s, ok = lookupStreamSession(r); !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		touchStreamSession(s)
+		defer touchStreamSession(s)
+	}
+
+	s.pollMu.Lock()
+	defer s.pollMu.Unlock()
+
+	// Without a cursor the client is taken to have received everything sent so far
+	if resuming {
+		confirmed := s.cursor
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			var err error
+			if confirmed, err = strconv.Atoi(cursor); err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+		s.confirm(confirmed)
+	}
+
+	c := s.client
+	if resuming && len(s.unconfirmed) == 0 {
+		timer := time.NewTimer(longPollTimeout)
+		defer timer.Stop()
+
+		select {
+		case msg := <-c.send:
+			s.take(msg)
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+		}
+	}
+
+	// Return whatever else is already queued
+drain:
+	for len(s.unconfirmed) < maxPollEvents {
+		select {
+		case msg := <-c.send:
+			s.take(msg)
+		default:
+			break drain
+		}
+	}
+
+	if len(s.unconfirmed) == 0 {
+		select {
+		case <-c.done:
+			closeStreamSession(s)
+			http.Error(w, "Session closed", http.StatusGone)
+			return
+		default:
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"session": s.id,
+		"events":  append([]interface{}{}, s.unconfirmed...),
+		"cursor":  s.cursor,
+	})
+	if err != nil {
+		log.Printf("Error writing poll response: %v", err)
+	}
+}
+
+// take records msg as sent to the poll client and not yet confirmed
+func (s *streamSession) take(msg interface{}) {
+	s.unconfirmed = append(s.unconfirmed, msg)
+	s.cursor++
+}
+
+// confirm drops the unconfirmed events up to cursor, which the client has received
+func (s *streamSession) confirm(cursor int) {
+	first := s.cursor - len(s.unconfirmed)
+	n := cursor - first
+	if n <= 0 {
+		return
+	}
+	if n > len(s.unconfirmed) {
+		n = len(s.unconfirmed)
+	}
+	s.unconfirmed = s.unconfirmed[n:]
+}
+
+func touchStreamSession(s *streamSession) {
+	streamSessions.Lock()
+	s.lastPoll = time.Now()
+	streamSessions.Unlock()
+}
+
+// streamSendHandler accepts one protocol frame for an SSE or long-poll session.
+// Acks and errors are delivered on the session's stream, not in the response.
+func streamSendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s, ok := lookupStreamSession(r)
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	c := s.client
+	select {
+	case <-c.done:
+		http.Error(w, "Session closed", http.StatusGone)
+		return
+	default:
+	}
+
+	if c.hub.markActive(c) {
+		c.hub.notifyPresenceChanged(c.userID)
+	}
+	dispatch(c, data)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// startStreamSessionReaper closes long-poll sessions whose client stopped polling
+func startStreamSessionReaper() {
+	runEvery(pollSessionTimeout/2, func() {
+		cutoff := time.Now().Add(-pollSessionTimeout)
+
+		var expired []*streamSession
+		streamSessions.Lock()
+		for _, s := range streamSessions.sessions {
+			if s.polling && s.lastPoll.Before(cutoff) {
+				expired = append(expired, s)
+			}
+		}
+		streamSessions.Unlock()
+
+		for _, s := range expired {
+			closeStreamSession(s)
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package srco
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newStreamServer serves the realtime transports the way the forum mounts them
+func newStreamServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", handleWebSocket)
+	mux.HandleFunc("/events", sseHandler)
+	mux.HandleFunc("/poll", longPollHandler)
+	mux.HandleFunc("/send", streamSendHandler)
+	// Handlers touch the database as they return, so the test waits for them
+	var handlers sync.WaitGroup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		server.Close()
+		handlers.Wait()
+	})
+	return server
+}
+
+// dialTestWebSocket connects userID over a WebSocket
+func dialTestWebSocket(t *testing.T, server *httptest.Server, userID int) *websocket.Conn {
+	t.Helper()
+
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user_id=" + strconv.Itoa(userID)
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads frames from next until one matches, failing the test after five seconds
+func readUntil(t *testing.T, what string, next func() (map[string]interface{}, error), match func(map[string]interface{}) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		frame, err := next()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", what, err)
+		}
+		if match(frame) {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func chatFrom(userID int, text string) func(map[string]interface{}) bool {
+	return func(frame map[string]interface{}) bool {
+		message, _ := frame["message"].(map[string]interface{})
+		return frame["type"] == "chat_message" && message["from_id"] == float64(userID) && message["content"] == text
+	}
+}
+
+func sendOnStream(t *testing.T, server *httptest.Server, userID int, session string, frame string) {
+	t.Helper()
+
+	u := server.URL + "/send?user_id=" + strconv.Itoa(userID) + "&session=" + url.QueryEscape(session)
+	resp, err := http.Post(u, "application/json", strings.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("send = %d, want 202", resp.StatusCode)
+	}
+}
+
+// Chat messages cross between an SSE stream and a WebSocket in both directions
+func TestSSEDelivery(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	server := newStreamServer(t)
+	ws := dialTestWebSocket(t, server, bob)
+
+	resp, err := http.Get(server.URL + "/events?user_id=" + strconv.Itoa(alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	nextEvent := func() (map[string]interface{}, error) {
+		var data string
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			line = strings.TrimRight(line, "\n")
+			if line == "" && data != "" {
+				break
+			}
+			if strings.HasPrefix(line, "data: ") {
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		var frame map[string]interface{}
+		err := json.Unmarshal([]byte(data), &frame)
+		return frame, err
+	}
+
+	var session string
+	readUntil(t, "session event", nextEvent, func(frame map[string]interface{}) bool {
+		session, _ = frame["session"].(string)
+		return session != ""
+	})
+
+	sendOnStream(t, server, alice, session,
+		`{"type":"chat_message","content":{"to":`+strconv.Itoa(bob)+`,"content":"from sse"}}`)
+	readUntil(t, "ack on the stream", nextEvent, func(frame map[string]interface{}) bool {
+		return frame["type"] == "chat_ack"
+	})
+	readUntil(t, "message on the WebSocket", wsReader(ws), chatFrom(alice, "from sse"))
+
+	ws.WriteJSON(map[string]interface{}{
+		"type":    "chat_message",
+		"content": map[string]interface{}{"to": alice, "content": "from ws"},
+	})
+	readUntil(t, "message on the stream", nextEvent, chatFrom(bob, "from ws"))
+}
+
+func wsReader(conn *websocket.Conn) func() (map[string]interface{}, error) {
+	return func() (map[string]interface{}, error) {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var frame map[string]interface{}
+		err := conn.ReadJSON(&frame)
+		return frame, err
+	}
+}
+
+type pollResponse struct {
+	Session string                   `json:"session"`
+	Events  []map[string]interface{} `json:"events"`
+	Cursor  int                      `json:"cursor"`
+}
+
+// Long polls deliver messages from a WebSocket, repeat events until a cursor
+// confirms them, and carry messages sent from the session to the WebSocket
+func TestLongPollDelivery(t *testing.T) {
+	openTestDB(t)
+	previous := longPollTimeout
+	longPollTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { longPollTimeout = previous })
+
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	server := newStreamServer(t)
+	ws := dialTestWebSocket(t, server, bob)
+
+	poll := func(query string) pollResponse {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/poll?user_id=" + strconv.Itoa(alice) + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("poll = %d", resp.StatusCode)
+		}
+		var body pollResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	first := poll("")
+	session := "&session=" + url.QueryEscape(first.Session)
+	cursor := first.Cursor
+
+	ws.WriteJSON(map[string]interface{}{
+		"type":    "chat_message",
+		"content": map[string]interface{}{"to": alice, "content": "from ws"},
+	})
+
+	var delivered pollResponse
+	readUntil(t, "message in a poll", func() (map[string]interface{}, error) {
+		delivered = poll(session + "&cursor=" + strconv.Itoa(cursor))
+		cursor = delivered.Cursor
+		frame := map[string]interface{}{}
+		for _, event := range delivered.Events {
+			if chatFrom(bob, "from ws")(event) {
+				frame = event
+			}
+		}
+		return frame, nil
+	}, chatFrom(bob, "from ws"))
+
+	// Polling again with the cursor from before that response, as if it was
+	// lost, returns the message again
+	previousCursor := delivered.Cursor - len(delivered.Events)
+	again := poll(session + "&cursor=" + strconv.Itoa(previousCursor))
+	if len(again.Events) != len(delivered.Events) || !chatFrom(bob, "from ws")(again.Events[len(again.Events)-1]) {
+		t.Fatalf("unconfirmed events were not sent again: %v", again.Events)
+	}
+	if after := poll(session + "&cursor=" + strconv.Itoa(again.Cursor)); len(after.Events) != 0 {
+		t.Errorf("confirmed events sent again: %v", after.Events)
+	}
+
+	sendOnStream(t, server, alice, first.Session,
+		`{"type":"chat_message","content":{"to":`+strconv.Itoa(bob)+`,"content":"from poll"}}`)
+	readUntil(t, "message on the WebSocket", wsReader(ws), chatFrom(alice, "from poll"))
+}
+
+// A poll session whose client is closed, e.g. for falling behind, goes offline
+// without waiting for the next poll
+func TestClosedPollSessionGoesOffline(t *testing.T) {
+	openTestDB(t)
+	alice := createTestUser(t, "alice")
+
+	s, err := openStreamSession(alice, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.client.close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		defaultHub.online.RLock()
+		online := len(defaultHub.online.users[alice]) > 0
+		defaultHub.online.RUnlock()
+		if !online {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("closed poll session still online")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/poll?user_id="+strconv.Itoa(alice)+"&session="+s.id, nil)
+	w := httptest.NewRecorder()
+	longPollHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("poll on closed session = %d, want 404", w.Code)
+	}
+}
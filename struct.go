@@ -25,46 +25,125 @@ type Post struct {
 	Likes        int    `json:"likes"`
 	Dislikes     int    `json:"dislikes"`
 	UserReaction string `json:"user_reaction"`
+	Status       string `json:"status,omitempty"`
+	PublishAt    string `json:"publish_at,omitempty"`
 }
 
 type Comment struct {
-	ID        int    `json:"id"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	Author    string `json:"author"`
+	ID          int    `json:"id"`
+	Content     string `json:"content"`
+	ContentHTML string `json:"content_html"`
+	CreatedAt   string `json:"created_at"`
+	Author      string `json:"author"`
 }
 
 type PostWithAuthor struct {
-	ID             int       `json:"id"`
-	UserID         int       `json:"user_id"`
-	Title          string    `json:"title"`
-	Content        string    `json:"content"`
-	Category       string    `json:"category"`
-	CreatedAt      string    `json:"created_at"`
-	Likes          int       `json:"likes"`
-	Dislikes       int       `json:"dislikes"`
-	AuthorNickname string    `json:"author_nickname"`
-	UserReaction   string    `json:"user_reaction,omitempty"`
-	Comments       []Comment `json:"comments"`
+	ID             int          `json:"id"`
+	UserID         int          `json:"user_id"`
+	Title          string       `json:"title"`
+	Content        string       `json:"content"`
+	ContentHTML    string       `json:"content_html"`
+	Category       string       `json:"category"`
+	CreatedAt      string       `json:"created_at"`
+	Likes          int          `json:"likes"`
+	Dislikes       int          `json:"dislikes"`
+	AuthorNickname string       `json:"author_nickname"`
+	UserReaction   string       `json:"user_reaction,omitempty"`
+	Status         string       `json:"status"`
+	Comments       []Comment    `json:"comments"`
+	Attachments    []Attachment `json:"attachments"`
 }
 
 type UserProfile struct {
-	ID             int    `json:"id"`
-	Nickname       string `json:"nickname"`
-	Age            int    `json:"age"`
-	Gender         string `json:"gender"`
-	FirstName      string `json:"first_name"`
-	LastName       string `json:"last_name"`
-	Email          string `json:"email"`
-	ProfilePic     string `json:"profile_pic"`
-	ProfileThought string `json:"profile_thought"`
-	UserPosts      []Post `json:"user_posts"`
+	ID             int               `json:"id"`
+	Nickname       string            `json:"nickname"`
+	Age            int               `json:"age"`
+	Gender         string            `json:"gender"`
+	FirstName      string            `json:"first_name"`
+	LastName       string            `json:"last_name"`
+	Email          string            `json:"email"`
+	ProfilePic     string            `json:"profile_pic"`
+	ProfileThought string            `json:"profile_thought"`
+	Avatars        map[string]string `json:"avatars,omitempty"`
+	UserPosts      []Post            `json:"user_posts"`
 }
 
 type ChatMessage struct {
-	FromID    int    `json:"from_id"`
-	FromNick  string `json:"from_nick"`
-	ToID      int    `json:"to_id"`
+	ID         int         `json:"id"`
+	FromID     int         `json:"from_id"`
+	FromNick   string      `json:"from_nick"`
+	ToID       int         `json:"to_id"`
+	RoomID     int         `json:"room_id,omitempty"`
+	Content    string      `json:"content"`
+	Timestamp  string      `json:"timestamp"`
+	EditedAt   string      `json:"edited_at,omitempty"`
+	Deleted    bool        `json:"deleted,omitempty"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+type TrashedPost struct {
+	ID             int    `json:"id"`
+	UserID         int    `json:"user_id"`
+	Title          string `json:"title"`
+	Content        string `json:"content"`
+	Category       string `json:"category"`
+	CreatedAt      string `json:"created_at"`
+	AuthorNickname string `json:"author_nickname"`
+	DeletedAt      string `json:"deleted_at"`
+	DeletedBy      int    `json:"deleted_by"`
+}
+
+type TrashedComment struct {
+	ID        int    `json:"id"`
+	PostID    int    `json:"post_id"`
 	Content   string `json:"content"`
-	Timestamp string `json:"timestamp"`
+	CreatedAt string `json:"created_at"`
+	Author    string `json:"author"`
+	DeletedAt string `json:"deleted_at"`
+	DeletedBy int    `json:"deleted_by"`
+}
+
+type Attachment struct {
+	ID           int    `json:"id"`
+	PostID       int    `json:"post_id,omitempty"`
+	MessageID    int    `json:"message_id,omitempty"`
+	Filename     string `json:"filename"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	blobKey      string
+	thumbnailKey string
+}
+
+type Conversation struct {
+	PartnerID         int    `json:"partner_id"`
+	PartnerNickname   string `json:"partner_nickname"`
+	LastMessageID     int    `json:"last_message_id"`
+	LastMessageFromID int    `json:"last_message_from_id"`
+	LastMessage       string `json:"last_message"`
+	LastMessageAt     string `json:"last_message_at"`
+	Unread            int    `json:"unread"`
+}
+
+type Room struct {
+	ID        int          `json:"id"`
+	Name      string       `json:"name"`
+	OwnerID   int          `json:"owner_id"`
+	CreatedAt string       `json:"created_at"`
+	Members   []RoomMember `json:"members"`
+}
+
+type RoomMember struct {
+	UserID   int    `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Role     string `json:"role"`
+}
+
+type ChatSearchResult struct {
+	Message       ChatMessage   `json:"message"`
+	Highlight     string        `json:"highlight"`
+	ContextBefore []ChatMessage `json:"context_before"`
+	ContextAfter  []ChatMessage `json:"context_after"`
 }
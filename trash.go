@@ -0,0 +1,187 @@
+package srco
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// How long soft-deleted posts and comments can be restored before they are purged
+	trashRetention = 30 * 24 * time.Hour
+
+	// How often the purge job runs
+	trashPurgeInterval = time.Hour
+)
+
+func getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !isModerator(userID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	posts := []TrashedPost{}
+	rows, err := db.Query(`
+        SELECT p.id, p.user_id, p.title, p.content, p.category, p.created_at,
+               COALESCE(u.nickname, '') as author_nickname,
+               p.deleted_at, COALESCE(p.deleted_by, 0) as deleted_by
+        FROM posts p
+        LEFT JOIN users u ON p.user_id = u.id
+        WHERE p.deleted_at IS NOT NULL
+        ORDER BY p.deleted_at DESC`)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var post TrashedPost
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.Category,
+			&post.CreatedAt, &post.AuthorNickname, &post.DeletedAt, &post.DeletedBy); err != nil {
+			log.Printf("Error scanning post: %v", err)
+			continue
+		}
+		posts = append(posts, post)
+	}
+
+	comments := []TrashedComment{}
+	commentRows, err := db.Query(`
+        SELECT c.id, c.post_id, c.content, c.created_at,
+               COALESCE(u.nickname, '') as author,
+               c.deleted_at, COALESCE(c.deleted_by, 0) as deleted_by
+        FROM comments c
+        LEFT JOIN users u ON c.user_id = u.id
+        WHERE c.deleted_at IS NOT NULL
+        ORDER BY c.deleted_at DESC`)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer commentRows.Close()
+	for commentRows.Next() {
+		var comment TrashedComment
+		if err := commentRows.Scan(&comment.ID, &comment.PostID, &comment.Content, &comment.CreatedAt,
+			&comment.Author, &comment.DeletedAt, &comment.DeletedBy); err != nil {
+			log.Printf("Error scanning comment: %v", err)
+			continue
+		}
+		comments = append(comments, comment)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"posts":          posts,
+		"comments":       comments,
+		"retention_days": int(trashRetention.Hours() / 24),
+	})
+}
+
+func restorePostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		PostID int `json:"post_id"`
+		UserID int `json:"user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if !isModerator(request.UserID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE posts 
+		SET deleted_at = NULL, deleted_by = NULL 
+		WHERE id = ? AND deleted_at IS NOT NULL AND deleted_at >= datetime('now', ?)`,
+		request.PostID, sqliteAgo(trashRetention))
+	if err != nil {
+		http.Error(w, "Error restoring post", http.StatusInternalServerError)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Post not found in trash", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		CommentID int `json:"comment_id"`
+		UserID    int `json:"user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if !isModerator(request.UserID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE comments 
+		SET deleted_at = NULL, deleted_by = NULL 
+		WHERE id = ? AND deleted_at IS NOT NULL AND deleted_at >= datetime('now', ?)`,
+		request.CommentID, sqliteAgo(trashRetention))
+	if err != nil {
+		http.Error(w, "Error restoring comment", http.StatusInternalServerError)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Comment not found in trash", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// startTrashPurger runs purgeTrash in the background every trashPurgeInterval
+func startTrashPurger() {
+	runEvery(trashPurgeInterval, func() {
+		if err := purgeTrash(); err != nil {
+			log.Printf("Error purging trash: %v", err)
+		}
+	})
+}
+
+// purgeTrash hard-deletes posts and comments that have been in the trash longer
+// than trashRetention, together with the reactions, comments and attachments of purged posts
+func purgeTrash() error {
+	expiredPosts := `SELECT id FROM posts WHERE deleted_at IS NOT NULL AND deleted_at < datetime('now', ?)`
+	_, err := deleteWithAttachments("post_id IN ("+expiredPosts+")", []interface{}{sqliteAgo(trashRetention)},
+		"DELETE FROM likes_dislikes WHERE post_id IN ("+expiredPosts+")",
+		"DELETE FROM comments WHERE post_id IN ("+expiredPosts+")",
+		"DELETE FROM comments WHERE deleted_at IS NOT NULL AND deleted_at < datetime('now', ?)",
+		"DELETE FROM posts WHERE deleted_at IS NOT NULL AND deleted_at < datetime('now', ?)",
+	)
+	return err
+}
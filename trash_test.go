@@ -0,0 +1,100 @@
+package srco
+
+import (
+	"net/http"
+	"testing"
+)
+
+// trashTestPost inserts a post and a comment on it, both deleted the given
+// SQLite modifier ago, e.g. "-1 day", and returns their IDs
+func trashTestPost(t *testing.T, author int, deletedAgo string) (postID, commentID int) {
+	t.Helper()
+
+	result, err := db.Exec(`
+		INSERT INTO posts (user_id, title, content, category, deleted_at, deleted_by)
+		VALUES (?, 'title', 'body', 'general', datetime('now', ?), ?)`, author, deletedAgo, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	postID = int(id)
+
+	result, err = db.Exec(`
+		INSERT INTO comments (post_id, user_id, content, deleted_at, deleted_by)
+		VALUES (?, ?, 'comment', datetime('now', ?), ?)`, postID, author, deletedAgo, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ = result.LastInsertId()
+	return postID, int(id)
+}
+
+func isTrashed(t *testing.T, table string, id int) bool {
+	t.Helper()
+
+	var trashed bool
+	if err := db.QueryRow("SELECT deleted_at IS NOT NULL FROM "+table+" WHERE id = ?", id).Scan(&trashed); err != nil {
+		t.Fatal(err)
+	}
+	return trashed
+}
+
+func TestRestoreRequiresModerator(t *testing.T) {
+	openTestDB(t)
+	author := createTestUser(t, "alice")
+	moderator := createTestUser(t, "mod")
+	if _, err := db.Exec("UPDATE users SET role = 'moderator' WHERE id = ?", moderator); err != nil {
+		t.Fatal(err)
+	}
+	postID, commentID := trashTestPost(t, author, "-1 day")
+
+	if w := postJSON(restorePostHandler, "/trash/restore/post", map[string]int{"post_id": postID, "user_id": author}); w.Code != http.StatusUnauthorized {
+		t.Errorf("restore post by member = %d, want 401", w.Code)
+	}
+	if w := postJSON(restoreCommentHandler, "/trash/restore/comment", map[string]int{"comment_id": commentID, "user_id": author}); w.Code != http.StatusUnauthorized {
+		t.Errorf("restore comment by member = %d, want 401", w.Code)
+	}
+	if !isTrashed(t, "posts", postID) || !isTrashed(t, "comments", commentID) {
+		t.Fatal("member restored from the trash")
+	}
+
+	if w := postJSON(restorePostHandler, "/trash/restore/post", map[string]int{"post_id": postID, "user_id": moderator}); w.Code != http.StatusOK {
+		t.Errorf("restore post by moderator = %d, want 200", w.Code)
+	}
+	if w := postJSON(restoreCommentHandler, "/trash/restore/comment", map[string]int{"comment_id": commentID, "user_id": moderator}); w.Code != http.StatusOK {
+		t.Errorf("restore comment by moderator = %d, want 200", w.Code)
+	}
+	if isTrashed(t, "posts", postID) || isTrashed(t, "comments", commentID) {
+		t.Error("moderator restore left items in the trash")
+	}
+}
+
+func TestPurgeTrashKeepsRecentItems(t *testing.T) {
+	openTestDB(t)
+	author := createTestUser(t, "alice")
+	recentPost, recentComment := trashTestPost(t, author, "-29 days")
+	expiredPost, expiredComment := trashTestPost(t, author, "-31 days")
+
+	// A live comment on the expired post goes with it
+	result, err := db.Exec("INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, 'reply')", expiredPost, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := result.LastInsertId()
+
+	if err := purgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(table string, id interface{}) bool {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE id = ?", id).Scan(&n)
+		return n == 1
+	}
+	if !exists("posts", recentPost) || !exists("comments", recentComment) {
+		t.Error("purge removed items inside the retention window")
+	}
+	if exists("posts", expiredPost) || exists("comments", expiredComment) || exists("comments", reply) {
+		t.Error("purge kept items past the retention window")
+	}
+}
@@ -2,144 +2,209 @@ package srco
 
 import (
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 var (
-	// WebSocket upgrader
-	upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
+	// Origins other than the server's own that may open WebSockets, e.g. "https://forum.example.com"
+	allowedOrigins []string
+
+	// Buffer sizes in bytes for WebSocket reads and writes
+	wsReadBufferSize  = 4096
+	wsWriteBufferSize = 4096
+
+	// Whether to negotiate permessage-deflate with clients that offer it
+	wsCompression = false
+
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+
+	// Time allowed between messages from the peer; any message or pong extends it
+	pongWait = 60 * time.Second
+
+	// How often pings are sent, must be less than pongWait
+	pingPeriod = 54 * time.Second
 
-	// Map to store online users and their connections
-	onlineUsers = struct {
-		sync.RWMutex
-		users map[int]*websocket.Conn
-	}{users: make(map[int]*websocket.Conn)}
+	// Maximum size in bytes of a message from the peer
+	maxMessageSize int64 = 8192
 )
 
 // Add WebSocket handler
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    wsReadBufferSize,
+		WriteBufferSize:   wsWriteBufferSize,
+		EnableCompression: wsCompression,
+		CheckOrigin:       checkOrigin,
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
-	defer conn.Close()
 
 	userID := r.URL.Query().Get("user_id")
 	if userID == "" {
+		conn.Close()
 		return
 	}
 
 	uid, err := strconv.Atoi(userID)
 	if err != nil {
+		conn.Close()
 		return
 	}
 
-	// Add user to online users
-	onlineUsers.Lock()
-	if oldConn, exists := onlineUsers.users[uid]; exists {
-		oldConn.Close() // Close old connection if exists
-	}
-	onlineUsers.users[uid] = conn
-	onlineUsers.Unlock()
+	// A peer that stops answering pings misses the read deadline and is dropped
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	h := defaultHub
+	c := newClient(h, uid, conn)
+	go c.writePump()
 
-	// Broadcast updated user list
-	broadcastOnlineUsers()
+	h.connect(c)
+	defer h.disconnect(c)
+
+	// Replay messages that arrived while this device was disconnected
+	lastSeenID, _ := strconv.Atoi(r.URL.Query().Get("last_seen_id"))
+	replayPendingMessages(c, lastSeenID)
 
 	// Keep connection alive and handle messages
 	for {
-		var msg struct {
-			Type    string      `json:"type"`
-			Content interface{} `json:"content"`
-		}
-
-		err := conn.ReadJSON(&msg)
+		_, data, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Printf("WebSocket peer for user %d stopped responding", uid)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
-			onlineUsers.Lock()
-			delete(onlineUsers.users, uid)
-			onlineUsers.Unlock()
-			broadcastOnlineUsers()
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
 
-		switch msg.Type {
-		case "requestUserList":
-			onlineUsers.RLock()
-			if _, ok := onlineUsers.users[uid]; ok {
-				broadcastOnlineUsers()
-			}
-			onlineUsers.RUnlock()
-		case "chat_message":
-			if chatMsg, ok := msg.Content.(map[string]interface{}); ok {
-				toID := int(chatMsg["to"].(float64))
-				content := chatMsg["content"].(string)
-
-				// Get sender's nickname
-				var fromNick string
-				err := db.QueryRow("SELECT nickname FROM users WHERE id = ?", uid).Scan(&fromNick)
-				if err != nil {
-					continue
-				}
-
-				// Create message object
-				message := ChatMessage{
-					FromID:    uid,
-					FromNick:  fromNick,
-					ToID:      toID,
-					Content:   content,
-					Timestamp: time.Now().Format(time.RFC3339),
-				}
-
-				// Store message in database
-				_, err = db.Exec(`
-					INSERT INTO chat_messages (from_id, to_id, content, created_at)
-					VALUES (?, ?, ?, CURRENT_TIMESTAMP)`,
-					message.FromID, message.ToID, message.Content)
-				if err != nil {
-					log.Printf("Error storing chat message: %v", err)
-					continue
-				}
-
-				// Send message to recipient if online
-				onlineUsers.RLock()
-				if recipientConn, ok := onlineUsers.users[toID]; ok {
-					recipientConn.WriteJSON(map[string]interface{}{
-						"type":    "chat_message",
-						"message": message,
-					})
-				}
-				onlineUsers.RUnlock()
-			}
-		case "typing_status":
-			if typingStatus, ok := msg.Content.(map[string]interface{}); ok {
-				toID := int(typingStatus["to"].(float64))
-				isTyping := typingStatus["isTyping"].(bool)
-
-				// Send typing status to recipient if online
-				onlineUsers.RLock()
-				if recipientConn, ok := onlineUsers.users[toID]; ok {
-					recipientConn.WriteJSON(map[string]interface{}{
-						"type":      "typing_status",
-						"from_id":   uid,
-						"is_typing": isTyping,
-					})
-				}
-				onlineUsers.RUnlock()
-			}
+		if h.markActive(c) {
+			h.notifyPresenceChanged(uid)
+		}
+		dispatch(c, data)
+	}
+}
+
+// checkOrigin accepts the server's own origin and those in allowedOrigins, so
+// other sites cannot open a WebSocket with a visitor's credentials. Requests
+// without an Origin header do not come from browsers and are let through.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), strings.TrimSuffix(origin, "/")) {
+			return true
+		}
+	}
+
+	log.Printf("Rejected WebSocket from origin %q", origin)
+	return false
+}
+
+func handleRequestUserList(c *client, env *envelope) error {
+	sendUserList(c)
+	return nil
+}
+
+func handleChatMessage(c *client, env *envelope) error {
+	var content chatMessageContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+	if content.To <= 0 || (strings.TrimSpace(content.Content) == "" && content.AttachmentID == 0) {
+		return errInvalidContent
+	}
+	if err := checkMessageLength(content.Content); err != nil {
+		return err
+	}
+	if isBlockedBetween(c.userID, content.To) {
+		return errBlocked
+	}
+
+	// Get sender's nickname
+	var fromNick string
+	err := db.QueryRow("SELECT nickname FROM users WHERE id = ?", c.userID).Scan(&fromNick)
+	if err != nil {
+		return err
+	}
+
+	// Create message object
+	message := ChatMessage{
+		FromID:    c.userID,
+		FromNick:  fromNick,
+		ToID:      content.To,
+		Content:   content.Content,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	// Store message in database; it stays pending until the recipient acknowledges it
+	if err := storeChatMessage(&message, content.AttachmentID); err != nil {
+		if _, ok := err.(*protocolError); ok {
+			return err
 		}
+		log.Printf("Error storing chat message: %v", err)
+		return errInternal
+	}
+
+	// Tell the sender the server accepted the message and which ID it was given
+	c.enqueue(map[string]interface{}{
+		"type":       "chat_ack",
+		"request_id": env.RequestID,
+		"message_id": message.ID,
+		"timestamp":  message.Timestamp,
+	})
+
+	// Send message to all of the recipient's connections, and to the sender's other devices
+	event := map[string]interface{}{
+		"type":    "chat_message",
+		"message": message,
+	}
+	c.hub.sendToUser(message.ToID, event)
+	if message.ToID != c.userID {
+		c.hub.sendToUserExcept(c.userID, c, event)
 	}
+	return nil
+}
+
+func handleTypingStatus(c *client, env *envelope) error {
+	var content typingStatusContent
+	if err := decodeContent(env, &content); err != nil {
+		return err
+	}
+	if content.To <= 0 {
+		return errInvalidContent
+	}
+	if isBlockedBetween(c.userID, content.To) {
+		return errBlocked
+	}
+
+	// Send typing status to recipient if online
+	c.hub.sendToUser(content.To, map[string]interface{}{
+		"type":      "typing_status",
+		"from_id":   c.userID,
+		"is_typing": content.IsTyping,
+	})
+	return nil
 }
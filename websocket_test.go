@@ -0,0 +1,65 @@
+package srco
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	previous := allowedOrigins
+	allowedOrigins = []string{"https://app.example.com/"}
+	t.Cleanup(func() { allowedOrigins = previous })
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"same host", "https://forum.example.com", true},
+		{"same host, different case", "https://FORUM.example.com", true},
+		{"allowlisted with trailing slash in config", "https://app.example.com", true},
+		{"allowlisted with trailing slash in header", "https://app.example.com/", true},
+		{"other site", "https://evil.com", false},
+		{"host as subdomain of other site", "http://forum.example.com.evil.com", false},
+		{"allowlisted host with other scheme", "http://app.example.com", false},
+		{"opaque origin", "null", false},
+		{"no origin header", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.Host = "forum.example.com"
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checkOrigin(r); got != tt.want {
+				t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+// A rejected origin fails the handshake before a connection is set up
+func TestWebSocketRejectsForeignOrigin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.com"}})
+	if err == nil {
+		t.Fatal("handshake from https://evil.com succeeded")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("handshake response = %v, want 403", resp)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {server.URL}})
+	if err != nil {
+		t.Fatalf("handshake from the server's own origin: %v", err)
+	}
+	conn.Close()
+}